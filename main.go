@@ -6,8 +6,12 @@ import (
 	"goFrame/src/handlers"
 	"goFrame/src/routes"
 	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils/upload"
 	"log"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -21,22 +25,46 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Load the Nostr key and relays
+	if err := nostr.LoadConfig("nostr.yml"); err != nil {
+		log.Fatalf("Failed to load Nostr config: %v", err)
+	}
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/btc-price", api.FetchBitcoinPrice)
 	mux.HandleFunc("/api/btc-price-log", api.ServePriceLogs)
+	mux.HandleFunc("/api/convert", api.ConvertHandler)
 	mux.HandleFunc("/api/gold-price", api.GoldPriceHandler)
 	mux.HandleFunc("/api/rsg-price", api.RSGPriceHandler)
 	mux.HandleFunc("/api/rsg-price-log", api.ServeRSGPriceLogs)
 	mux.HandleFunc("/api/file-upload", api.HandleFileUpload)
+	mux.HandleFunc("/api/file-upload/quote", api.UploadQuoteHandler)
+	mux.HandleFunc("/api/uploads", api.MyUploadsHandler)
+	mux.HandleFunc(api.NIP96APIPath, api.NIP96Handler)
+	mux.HandleFunc(api.NIP96APIPath+"/", api.NIP96Handler)
+	mux.HandleFunc("/uploads/", api.ServeLocalUpload)
 	mux.HandleFunc("/create-invoice", api.HandleNostrInvoice)
 	mux.HandleFunc("/invoice-events", api.InvoiceEventsHandler)
 	mux.HandleFunc("/check-name", api.CheckNameHandler)
 	mux.HandleFunc("/check-npub", api.CheckNpubHandler)
 	mux.HandleFunc("/api/smsnotes", api.SMSHandler)
+	mux.HandleFunc("/api/stream-data", api.GetStreamData)
+	mux.HandleFunc("/api/stream/transcoder", api.GetTranscoderStatus)
+	mux.HandleFunc("/api/stream/state", api.GetStreamState)
+	mux.HandleFunc("/api/stream/events", api.StreamStateEventsHandler)
+	mux.HandleFunc("/api/stream/chat", api.StreamChatEventsHandler)
+	mux.HandleFunc("/api/stream/chat/moderation", api.StreamChatModerationHandler)
+	mux.HandleFunc("/api/stream/schedule", api.StreamScheduleHandler)
+	mux.HandleFunc("/api/past-streams", api.PastStreamsHandler)
+	mux.HandleFunc("/api/past-streams/pin", api.PastStreamPinHandler)
+	mux.HandleFunc("/api/past-streams/storage", api.PastStreamStorageHandler)
+	mux.HandleFunc("/api/stream/clips", api.StreamClipsHandler)
+	mux.HandleFunc("/live/", api.ServeLiveHLS)
 
 	// Access-Control-Allow-Origin", "*" for nostr.json
 	mux.HandleFunc("/.well-known/nostr.json", utils.ServeWellKnownNostr)
+	mux.HandleFunc("/.well-known/nostr/nip96.json", api.NIP96InfoHandler)
 
 	// Initialize Routes
 	routes.InitializeRoutes(mux)
@@ -69,8 +97,27 @@ func main() {
 		handlers.PathInvoiceRequest(w, r)
 	})
 
-	// Start logging Bitcoin prices as a goroutine with 5 minute interval
+	// Refresh the Bitcoin price snapshot served by /api/btc-price,
+	// closing priceReady once the first refresh has a price
+	priceReady := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(utils.BitcoinPriceRefreshInterval)
+		defer ticker.Stop()
+
+		ready := false
+		for {
+			if utils.RefreshBitcoinPrice() && !ready {
+				ready = true
+				close(priceReady)
+			}
+			<-ticker.C
+		}
+	}()
+
+	// Start logging Bitcoin prices as a goroutine with 5 minute interval,
+	// the first entry as soon as the refresher has a price
 	go func() {
+		<-priceReady
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 
@@ -80,6 +127,17 @@ func main() {
 		}
 	}()
 
+	// Delete uploads once they expire
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			upload.DeleteExpired()
+			<-ticker.C
+		}
+	}()
+
 	// Start logging Gold prices as a goroutine with 5 minute interval
 	go func() {
 		// Log immediately on startup
@@ -108,6 +166,11 @@ func main() {
 		}
 	}()
 
+	// Start the live stream ingest when stream metadata is configured
+	if _, err := os.Stat("stream.yml"); err == nil {
+		go stream.MonitorStream()
+	}
+
 	fmt.Printf("Server is running on http://localhost:%d\n", utils.AppConfig.Server.Port)
 	http.ListenAndServe(fmt.Sprintf(":%d", utils.AppConfig.Server.Port), mux)
 }
@@ -3,186 +3,66 @@ package api
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
 	"strconv"
-)
+	"strings"
+	"time"
 
-// API endpoints and API keys
-const (
-	coingeckoURL      = "https://api.coingecko.com/api/v3/simple/price?ids=bitcoin&vs_currencies=usd"
-	coinmarketcapURL  = "https://pro-api.coinmarketcap.com/v1/cryptocurrency/quotes/latest?id=1&convert=USD"
-	blockchainInfoURL = "https://blockchain.info/ticker"
-	coinbaseURL       = "https://api.coinbase.com/v2/prices/BTC-USD/spot"
-	coinmarketcapKey  = "YOUR_COINMARKETCAP_API_KEY" // Replace with your API key
+	"goFrame/src/utils"
 )
 
 // PriceResponse represents the JSON response for the endpoint
 type PriceResponse struct {
-	Price string `json:"Price"`
-	Error string `json:"error,omitempty"`
+	Price     string              `json:"Price"`
+	Currency  string              `json:"currency,omitempty"`
+	Sources   []utils.SourceQuote `json:"sources,omitempty"`    // Quotes the price is the median of
+	Excluded  []utils.SourceQuote `json:"excluded,omitempty"`   // Sources that failed, are resting or were too far off
+	UpdatedAt int64               `json:"updated_at,omitempty"` // Unix time the price was fetched
+	Age       int64               `json:"age"`                  // Seconds since the price was fetched
+	Error     string              `json:"error,omitempty"`
 }
 
-// FetchBitcoinPrice handles the /api/btc-price endpoint
+// FetchBitcoinPrice handles the /api/btc-price endpoint, serving the price kept by the
+// background refresher in ?currency= (USD by default). Clients can revalidate with If-None-Match.
 func FetchBitcoinPrice(w http.ResponseWriter, r *http.Request) {
-	var (
-		coingeckoPrice      float64
-		coinmarketcapPrice  float64
-		blockchain15mPrice  float64
-		coinbasePrice       float64
-		validPrices         []float64
-	)
-
-	// Fetch prices from the different APIs
-	coingeckoPrice = fetchCoingeckoPrice()
-	coinmarketcapPrice = fetchCoinmarketcapPrice()
-	blockchain15mPrice = fetchBlockchainInfoPrice()
-	coinbasePrice = fetchCoinbasePrice()
-
-	// Collect valid prices
-	if coingeckoPrice > 0 {
-		validPrices = append(validPrices, coingeckoPrice)
-	}
-	if coinmarketcapPrice > 0 {
-		validPrices = append(validPrices, coinmarketcapPrice)
+	currency := strings.ToUpper(r.URL.Query().Get("currency"))
+	if currency == "" {
+		currency = "USD"
 	}
-	if blockchain15mPrice > 0 {
-		validPrices = append(validPrices, blockchain15mPrice)
-	}
-	if coinbasePrice > 0 {
-		validPrices = append(validPrices, coinbasePrice)
-	}
-
-	// Calculate average price
-	var averagePrice float64
-	if len(validPrices) > 0 {
-		for _, price := range validPrices {
-			averagePrice += price
-		}
-		averagePrice /= float64(len(validPrices))
-	}
-
-	// Prepare the response
-	response := PriceResponse{}
-	if len(validPrices) > 0 {
-		response.Price = fmt.Sprintf("%.2f", averagePrice)
-	} else {
-		response.Error = "Unable to fetch Bitcoin price from all sources."
-	}
-
-	// Return response as JSON
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
-// Helper functions to fetch prices
-func fetchCoingeckoPrice() float64 {
-	resp, err := http.Get(coingeckoURL)
-	if err != nil {
-		return 0
-	}
-	defer resp.Body.Close()
-
-	var data map[string]map[string]float64
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0
-	}
-	return data["bitcoin"]["usd"]
-}
-
-func fetchCoinmarketcapPrice() float64 {
-	req, err := http.NewRequest("GET", coinmarketcapURL, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return 0
-	}
-	req.Header.Set("X-CMC_PRO_API_KEY", coinmarketcapKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error performing request:", err)
-		return 0
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		//fmt.Printf("CoinMarketCap API returned status: %d\n", resp.StatusCode)
-		return 0
-	}
-
-	var data map[string]interface{}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return 0
+	if !slices.Contains(utils.PriceCurrencies(), currency) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(PriceResponse{Error: "Unsupported currency: " + currency})
+		return
 	}
 
-	if err := json.Unmarshal(body, &data); err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return 0
-	}
-
-	// Validate nested data structure
-	quoteData, ok := data["data"].(map[string]interface{})
-	if !ok {
-		fmt.Println("Error: Invalid 'data' field in CoinMarketCap response")
-		return 0
-	}
-
-	coinData, ok := quoteData["1"].(map[string]interface{})
-	if !ok {
-		fmt.Println("Error: Invalid '1' field in CoinMarketCap response")
-		return 0
-	}
-
-	quote, ok := coinData["quote"].(map[string]interface{})
-	if !ok {
-		fmt.Println("Error: Invalid 'quote' field in CoinMarketCap response")
-		return 0
-	}
-
-	usdData, ok := quote["USD"].(map[string]interface{})
-	if !ok {
-		fmt.Println("Error: Invalid 'USD' field in CoinMarketCap response")
-		return 0
-	}
-
-	price, ok := usdData["price"].(float64)
+	price, ok := utils.BitcoinPrice(currency)
 	if !ok {
-		fmt.Println("Error: Invalid 'price' field in CoinMarketCap response")
-		return 0
-	}
-
-	return price
-}
-
-
-func fetchBlockchainInfoPrice() float64 {
-	resp, err := http.Get(blockchainInfoURL)
-	if err != nil {
-		return 0
-	}
-	defer resp.Body.Close()
-
-	var data map[string]map[string]float64
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(utils.BitcoinPriceRefreshInterval.Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(PriceResponse{Currency: currency, Error: "Bitcoin price not available yet"})
+		return
 	}
-	return data["USD"]["15m"]
-}
 
-func fetchCoinbasePrice() float64 {
-	resp, err := http.Get(coinbaseURL)
-	if err != nil {
-		return 0
+	// Weak, since the age in the body changes while the price doesn't
+	etag := fmt.Sprintf(`W/"%s-%x"`, currency, price.UpdatedAt.UnixNano())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", price.UpdatedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
-	defer resp.Body.Close()
 
-	var data map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return 0
-	}
-	price, _ := strconv.ParseFloat(data["data"].(map[string]interface{})["amount"].(string), 64)
-	return price
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PriceResponse{
+		Price:     fmt.Sprintf("%.2f", price.Price),
+		Currency:  currency,
+		Sources:   price.Sources,
+		Excluded:  price.Excluded,
+		UpdatedAt: price.UpdatedAt.Unix(),
+		Age:       int64(price.Age() / time.Second),
+	})
 }
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+const satsPerBitcoin = 100_000_000
+
+// ConvertResponse represents the JSON response for /api/convert
+type ConvertResponse struct {
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Amount    float64 `json:"amount"`
+	Result    float64 `json:"result"`
+	UpdatedAt int64   `json:"updated_at,omitempty"` // Unix time of the oldest price used, absent between sats and BTC
+	Age       int64   `json:"age"`                  // Seconds since that price was fetched
+	Error     string  `json:"error,omitempty"`
+}
+
+// ConvertHandler handles /api/convert?from=USD&to=sat&amount=5, converting between sats,
+// BTC and the configured fiat currencies through the prices kept by the background refresher
+func ConvertHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := r.URL.Query()
+	from, to := normalizeUnit(query.Get("from")), normalizeUnit(query.Get("to"))
+	resp := ConvertResponse{From: from, To: to}
+
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || amount < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		w.WriteHeader(http.StatusBadRequest)
+		resp.Error = "amount must be a number of at least 0"
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	resp.Amount = amount
+
+	// Every unit is priced as how many of it make one bitcoin
+	var oldest time.Time
+	perBitcoin := func(unit string) (float64, int) {
+		switch unit {
+		case "sat":
+			return satsPerBitcoin, 0
+		case "BTC":
+			return 1, 0
+		}
+		if !slices.Contains(utils.PriceCurrencies(), unit) {
+			resp.Error = "Unsupported unit: " + unit
+			return 0, http.StatusBadRequest
+		}
+		price, ok := utils.BitcoinPrice(unit)
+		if !ok {
+			resp.Error = "Bitcoin price in " + unit + " not available yet"
+			return 0, http.StatusServiceUnavailable
+		}
+		if oldest.IsZero() || price.UpdatedAt.Before(oldest) {
+			oldest = price.UpdatedAt
+		}
+		return price.Price, 0
+	}
+
+	fromRate, status := perBitcoin(from)
+	if status == 0 {
+		var toRate float64
+		toRate, status = perBitcoin(to)
+		resp.Result = amount / fromRate * toRate
+	}
+	if status != 0 {
+		if status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", strconv.Itoa(int(utils.BitcoinPriceRefreshInterval.Seconds())))
+		}
+		resp.Result = 0
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Nothing is worth less than a millisat
+	if to == "sat" {
+		resp.Result = math.Round(resp.Result*1000) / 1000
+	}
+	if !oldest.IsZero() {
+		resp.UpdatedAt = oldest.Unix()
+		resp.Age = int64(time.Since(oldest) / time.Second)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// normalizeUnit accepts sat, sats and currency codes in any case
+func normalizeUnit(unit string) string {
+	unit = strings.ToUpper(strings.TrimSpace(unit))
+	if unit == "SAT" || unit == "SATS" {
+		return "sat"
+	}
+	return unit
+}
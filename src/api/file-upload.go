@@ -1,131 +1,328 @@
 package api
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils/upload"
 )
 
+// multipartOverhead allows for the boundaries, headers and other fields around the file
+const multipartOverhead = 1 << 20
+
 type UploadResponse struct {
-	URL   string `json:"url"`
-	Token string `json:"token,omitempty"`
-	Error string `json:"error,omitempty"`
+	URL       string `json:"url"`
+	Token     string `json:"token,omitempty"`
+	Error     string `json:"error,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	Backend   string `json:"backend,omitempty"`
+	ID        string `json:"id,omitempty"`         // Record ID for /api/uploads
+	ExpiresAt int64  `json:"expires_at,omitempty"` // Unix time the file is deleted, if it expires
+
+	// NIP-94 fields, named after their tags
+	M        string `json:"m,omitempty"`
+	X        string `json:"x,omitempty"`
+	OX       string `json:"ox,omitempty"` // Hash of the file as uploaded, before metadata was stripped
+	Size     int64  `json:"size,omitempty"`
+	Dim      string `json:"dim,omitempty"`
+	Blurhash string `json:"blurhash,omitempty"`
+	Thumb    string `json:"thumb,omitempty"`
 }
 
-// HandleFileUpload proxies file uploads to 0x0.st
+// HandleFileUpload stores an uploaded file with the backend chosen in config.yml
 func HandleFileUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse the multipart form (32MB max memory)
-	err := r.ParseMultipartForm(32 << 20)
+	owner, auth, err := uploadOwner(r)
 	if err != nil {
-		writeErrorResponse(w, "Failed to parse form data", http.StatusBadRequest)
+		writeErrorResponse(w, "Invalid authorization: "+err.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	// Get the file from the form
-	file, fileHeader, err := r.FormFile("file")
-	if err != nil {
-		writeErrorResponse(w, "No file provided", http.StatusBadRequest)
+	received, uploadErr := receiveUpload(w, r, owner, auth)
+	if uploadErr != nil {
+		writeErrorResponse(w, uploadErr.Message, uploadErr.Status)
 		return
 	}
-	defer file.Close()
+	stored, thumb, record := received.Stored, received.Thumb, received.Record
 
-	// Check file size (512MB limit)
-	maxSize := int64(512 * 1024 * 1024) // 512MB
-	if fileHeader.Size > maxSize {
-		writeErrorResponse(w, "File too large. Maximum size is 512MB", http.StatusBadRequest)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadResponse{
+		URL:       absoluteUploadURL(r, stored.URL),
+		Token:     stored.Token,
+		SHA256:    stored.SHA256,
+		Backend:   record.Backend,
+		ID:        record.ID,
+		ExpiresAt: record.ExpiresAt,
+		M:         stored.ContentType,
+		X:         stored.SHA256,
+		OX:        stored.OriginalSHA256,
+		Size:      stored.Size,
+		Dim:       stored.Dim,
+		Blurhash:  stored.Blurhash,
+		Thumb:     absoluteUploadURL(r, thumb.URL),
+	})
+}
 
-	// Read the file content
-	fileContent, err := io.ReadAll(file)
-	if err != nil {
-		writeErrorResponse(w, "Failed to read file", http.StatusInternalServerError)
-		return
+// uploadError is a failed upload as the client should see it
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+// receivedUpload is a stored upload and its record, which has no ID when it wasn't recorded
+type receivedUpload struct {
+	Stored upload.Stored
+	Thumb  upload.Stored
+	Record upload.Record
+}
+
+// receiveUpload takes the "file" field of a multipart upload through the paywall, the size
+// limits and the upload pipeline, and records it for its owner. HandleFileUpload and the
+// NIP-96 API share it. An "expiration" field, in Unix time, can shorten how long the file
+// is kept. A NIP-98 payload tag in auth has to match the SHA-256 of the whole body.
+func receiveUpload(w http.ResponseWriter, r *http.Request, owner string, auth nostr.HTTPAuth) (receivedUpload, *uploadError) {
+	// Free uploads are limited to the free size, paid ones to what their quote covers
+	uploaded := false
+	days := 0
+	maxSize := upload.FreeMaxSize()
+	tooLarge := &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("File too large. Maximum size is %dMB", maxSize>>20)}
+	if token := r.Header.Get("X-Payment-Token"); token != "" && upload.PaymentsEnabled() {
+		quote, err := upload.ClaimQuote(token)
+		switch {
+		case errors.Is(err, upload.ErrPaymentRequired):
+			return receivedUpload{}, &uploadError{http.StatusPaymentRequired, "Payment not found or not paid yet"}
+		case errors.Is(err, upload.ErrQuoteUsed):
+			return receivedUpload{}, &uploadError{http.StatusPaymentRequired, "This payment has already been used for an upload"}
+		case err != nil:
+			log.Printf("Failed to check upload payment: %v", err)
+			return receivedUpload{}, &uploadError{http.StatusBadGateway, "Failed to check payment"}
+		}
+
+		// Use up the payment once the file is stored, give it back if it isn't
+		defer func() {
+			if !uploaded {
+				upload.ReleaseQuote(quote.PaymentHash)
+			} else if err := upload.RedeemQuote(quote.PaymentHash); err != nil {
+				log.Printf("Failed to mark upload payment %s as used: %v", quote.PaymentHash, err)
+			}
+		}()
+		maxSize = quote.Size
+		days = quote.Days
+		tooLarge.Message = fmt.Sprintf("File too large. This payment covers up to %dMB", maxSize>>20)
+	} else if upload.PaymentsEnabled() {
+		tooLarge = &uploadError{http.StatusPaymentRequired, fmt.Sprintf("Files over %dMB have to be paid for, request a quote first", maxSize>>20)}
 	}
 
-	// Create a new form for 0x0.st
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
+	// Reject oversized uploads before reading them, leaving room for the form around the file
+	if r.ContentLength > maxSize+multipartOverhead {
+		return receivedUpload{}, tooLarge
+	}
+	limited := http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	bodySum := sha256.New()
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(limited, bodySum), limited}
 
-	// Add the file to the form
-	fileWriter, err := writer.CreateFormFile("file", fileHeader.Filename)
+	// Read the form part by part so the file streams straight to the backend. The body is
+	// hashed on the way, a NIP-98 payload tag can only be checked once all of it is read.
+	reader, err := r.MultipartReader()
 	if err != nil {
-		writeErrorResponse(w, "Failed to create form file", http.StatusInternalServerError)
-		return
+		return receivedUpload{}, &uploadError{http.StatusBadRequest, "Failed to parse form data"}
+	}
+	var part *multipart.Part
+	var maxBytesErr *http.MaxBytesError
+	expiration := ""
+	for {
+		part, err = reader.NextPart()
+		if errors.As(err, &maxBytesErr) {
+			return receivedUpload{}, tooLarge
+		}
+		if err != nil {
+			return receivedUpload{}, &uploadError{http.StatusBadRequest, "No file provided"}
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			break
+		}
+		if part.FormName() == "expiration" {
+			expiration = readFormValue(part)
+		}
+		part.Close()
 	}
+	defer part.Close()
 
-	_, err = fileWriter.Write(fileContent)
+	backend, err := upload.ConfiguredBackend()
 	if err != nil {
-		writeErrorResponse(w, "Failed to write file data", http.StatusInternalServerError)
-		return
+		log.Printf("File upload misconfigured: %v", err)
+		return receivedUpload{}, &uploadError{http.StatusInternalServerError, "File uploads are not configured"}
 	}
 
-	// Add secret field for harder-to-guess URLs
-	err = writer.WriteField("secret", "")
-	if err != nil {
-		writeErrorResponse(w, "Failed to add secret field", http.StatusInternalServerError)
-		return
+	expires := requestedExpiry(upload.Expiry(days), expiration)
+	stored, err := upload.Receive(r.Context(), backend, upload.Incoming{
+		Name:        part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Body:        part,
+		Limit:       maxSize,
+		Expires:     expires,
+	})
+	switch {
+	case errors.Is(err, upload.ErrTooLarge) || errors.As(err, &maxBytesErr):
+		return receivedUpload{}, tooLarge
+	case errors.Is(err, upload.ErrInvalidImage):
+		return receivedUpload{}, &uploadError{http.StatusBadRequest, "The image is damaged or not what its contents claim"}
+	case errors.Is(err, upload.ErrTypeNotAllowed):
+		return receivedUpload{}, &uploadError{http.StatusUnsupportedMediaType, "Upload refused, " + err.Error()}
+	case errors.Is(err, upload.ErrRejected):
+		log.Printf("Refused upload %q: %v", part.FileName(), err)
+		return receivedUpload{}, &uploadError{http.StatusUnprocessableEntity, "Upload refused, " + err.Error()}
+	case errors.Is(err, upload.ErrScanFailed):
+		log.Printf("Upload of %q not scanned: %v", part.FileName(), err)
+		return receivedUpload{}, &uploadError{http.StatusServiceUnavailable, "The file couldn't be checked for viruses, try again later"}
+	case err != nil:
+		log.Printf("Upload to %s failed: %v", backend.Name(), err)
+		return receivedUpload{}, &uploadError{http.StatusBadGateway, "Failed to upload file"}
 	}
 
-	// Close the writer
-	err = writer.Close()
-	if err != nil {
-		writeErrorResponse(w, "Failed to finalize form", http.StatusInternalServerError)
-		return
+	var thumb upload.Stored
+	if stored.Thumb != nil {
+		thumb = *stored.Thumb
+	}
+	record := upload.Record{
+		Owner:          owner,
+		URL:            stored.URL,
+		Name:           part.FileName(),
+		ContentType:    stored.ContentType,
+		Size:           stored.Size,
+		SHA256:         stored.SHA256,
+		OriginalSHA256: stored.OriginalSHA256,
+		Backend:        backend.Name(),
+		Token:          stored.Token,
+		Dim:            stored.Dim,
+		Blurhash:       stored.Blurhash,
+		Thumb:          thumb.URL,
+		ThumbSHA256:    thumb.SHA256,
+		ThumbToken:     thumb.Token,
 	}
 
-	// Create request to 0x0.st
-	req, err := http.NewRequest("POST", "https://0x0.st", &requestBody)
-	if err != nil {
-		writeErrorResponse(w, "Failed to create upload request", http.StatusInternalServerError)
-		return
+	// Fields may follow the file. An expiration that comes too late for the backend still
+	// shortens the record, which is what deletes the file.
+	for {
+		next, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discardUpload(record)
+			if errors.As(err, &maxBytesErr) {
+				return receivedUpload{}, tooLarge
+			}
+			return receivedUpload{}, &uploadError{http.StatusBadRequest, "Failed to parse form data"}
+		}
+		if next.FormName() == "expiration" {
+			expires = requestedExpiry(expires, readFormValue(next))
+		}
+		next.Close()
 	}
 
-	// Set the content type with the boundary
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("User-Agent", "HappyTavern/1.0 (File Upload Service)")
+	// Whatever follows the closing boundary is part of the signed body too
+	if _, err := io.Copy(io.Discard, r.Body); errors.As(err, &maxBytesErr) {
+		discardUpload(record)
+		return receivedUpload{}, tooLarge
+	}
+	if err := auth.CheckPayload(hex.EncodeToString(bodySum.Sum(nil))); err != nil {
+		discardUpload(record)
+		return receivedUpload{}, &uploadError{http.StatusUnauthorized, "Invalid authorization: " + err.Error()}
+	}
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	uploaded = true
+	record.ExpiresAt = unixOrZero(expires)
+	// Every upload is recorded, even anonymous ones that never expire, so deleting
+	// another upload of the same content leaves this one's file in place
+	added, err := upload.AddRecord(record)
 	if err != nil {
-		writeErrorResponse(w, "Failed to upload file", http.StatusInternalServerError)
-		return
+		// The file is stored, it just can't be managed from here
+		log.Printf("Failed to record upload %s: %v", stored.URL, err)
+	} else {
+		record = added
 	}
-	defer resp.Body.Close()
+	return receivedUpload{Stored: stored, Thumb: thumb, Record: record}, nil
+}
 
-	// Read the response
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		writeErrorResponse(w, "Failed to read upload response", http.StatusInternalServerError)
-		return
+// absoluteUploadURL turns the site paths of files we serve ourselves into full URLs
+func absoluteUploadURL(r *http.Request, url string) string {
+	if url == "" {
+		return ""
 	}
+	if strings.HasPrefix(url, "/") {
+		return utils.PublicURL(r, url)
+	}
+	return url
+}
 
-	// Check if upload was successful
-	if resp.StatusCode != http.StatusOK {
-		writeErrorResponse(w, "Upload failed: "+string(responseBody), resp.StatusCode)
-		return
+// readFormValue reads a short form field
+func readFormValue(part *multipart.Part) string {
+	value, _ := io.ReadAll(io.LimitReader(part, 32))
+	return strings.TrimSpace(string(value))
+}
+
+// requestedExpiry shortens expires to an "expiration" field in Unix time. Times in the
+// past or after expires are ignored, a client can't keep a file longer than it paid for.
+func requestedExpiry(expires time.Time, expiration string) time.Time {
+	requested, err := strconv.ParseInt(expiration, 10, 64)
+	if err != nil || requested <= time.Now().Unix() {
+		return expires
+	}
+	if t := time.Unix(requested, 0); expires.IsZero() || t.Before(expires) {
+		return t
 	}
+	return expires
+}
 
-	// Get the management token from response headers
-	token := resp.Header.Get("X-Token")
+// discardUpload deletes a stored file whose request turned out to be invalid
+func discardUpload(record upload.Record) {
+	if err := upload.Discard(context.Background(), record); err != nil {
+		log.Printf("Failed to delete rejected upload %s: %v", record.URL, err)
+	}
+}
 
-	// Prepare success response
-	uploadResponse := UploadResponse{
-		URL:   string(responseBody),
-		Token: token,
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
 	}
+	return t.Unix()
+}
 
-	// Send JSON response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(uploadResponse)
+// ServeLocalUpload serves files stored by the local upload backend
+func ServeLocalUpload(w http.ResponseWriter, r *http.Request) {
+	// No directory listings or temporary files
+	name := strings.TrimPrefix(r.URL.Path, upload.LocalURLPrefix)
+	if name == "" || strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Uploaded content must never be interpreted as something else by the browser
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.StripPrefix(upload.LocalURLPrefix, http.FileServer(http.Dir(upload.LocalDir()))).ServeHTTP(w, r)
 }
 
 func writeErrorResponse(w http.ResponseWriter, message string, statusCode int) {
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils/upload"
+)
+
+// useLocalUploads stores uploads and their records in a temporary directory
+func useLocalUploads(t *testing.T) string {
+	dir := t.TempDir()
+	t.Cleanup(upload.UseDataDir(filepath.Join(dir, "data")))
+
+	saved := utils.AppConfig.Upload
+	t.Cleanup(func() { utils.AppConfig.Upload = saved })
+	utils.AppConfig.Upload = utils.UploadConfig{Backend: "local", Local: utils.LocalUploadConfig{Dir: filepath.Join(dir, "uploads")}}
+	return filepath.Join(dir, "uploads")
+}
+
+// uploadForm is a multipart body with the file and the other fields in the given order
+func uploadForm(t *testing.T, data []byte, fieldsAfterFile bool, fields map[string]string) (body []byte, contentType string) {
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	writeFields := func() {
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+	}
+	if !fieldsAfterFile {
+		writeFields()
+	}
+	file, err := form.CreateFormFile("file", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(data)
+	if fieldsAfterFile {
+		writeFields()
+	}
+	form.Close()
+	return buf.Bytes(), form.FormDataContentType()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func uploadRequest(body []byte, contentType string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, NIP96APIPath, bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	return r
+}
+
+func TestReceiveUploadFieldOrder(t *testing.T) {
+	for _, after := range []bool{false, true} {
+		t.Run("fields after file "+strconv.FormatBool(after), func(t *testing.T) {
+			dir := useLocalUploads(t)
+			data := []byte("kept for an hour")
+			expiration := time.Now().Add(time.Hour).Unix()
+
+			r := uploadRequest(uploadForm(t, data, after, map[string]string{"expiration": strconv.FormatInt(expiration, 10)}))
+			received, uploadErr := receiveUpload(httptest.NewRecorder(), r, "", nostr.HTTPAuth{})
+			if uploadErr != nil {
+				t.Fatalf("receiveUpload: %+v", uploadErr)
+			}
+			if received.Record.ExpiresAt != expiration {
+				t.Errorf("ExpiresAt = %d, want %d", received.Record.ExpiresAt, expiration)
+			}
+
+			stored, err := os.ReadFile(filepath.Join(dir, sha256Hex(data)+".txt"))
+			if err != nil || !bytes.Equal(stored, data) {
+				t.Errorf("stored file = %q, %v", stored, err)
+			}
+			// Nothing spooled is left behind
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 1 {
+				t.Errorf("%d files in the upload directory, want 1", len(entries))
+			}
+		})
+	}
+}
+
+func TestReceiveUploadPayload(t *testing.T) {
+	dir := useLocalUploads(t)
+	data := []byte("signed upload")
+
+	body, contentType := uploadForm(t, data, true, nil)
+	signed := sha256Hex(body)
+
+	r := uploadRequest(body, contentType)
+	if _, uploadErr := receiveUpload(httptest.NewRecorder(), r, "", nostr.HTTPAuth{Payload: signed}); uploadErr != nil {
+		t.Fatalf("matching payload: %+v", uploadErr)
+	}
+
+	// The same signature over a different body is refused and the file deleted again
+	os.RemoveAll(dir)
+	tampered := bytes.Replace(body, data, []byte("swapped upload"), 1)
+	r = uploadRequest(tampered, contentType)
+	_, uploadErr := receiveUpload(httptest.NewRecorder(), r, "", nostr.HTTPAuth{Payload: signed})
+	if uploadErr == nil || uploadErr.Status != http.StatusUnauthorized {
+		t.Fatalf("tampered body = %+v, want 401", uploadErr)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left from a tampered body", len(entries))
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+var liveFiles = http.StripPrefix("/live/", utils.ServeHLSFolderWithCORS("web/live"))
+
+// ServeLiveHLS serves the live HLS output and holds LL-HLS blocking playlist reloads
+func ServeLiveHLS(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/live/")
+	if !strings.HasSuffix(name, ".m3u8") {
+		liveFiles.ServeHTTP(w, r)
+		return
+	}
+
+	// Playlists change every few seconds and must never be cached
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Players reload playlists continuously, which makes them a good viewer heartbeat
+	stream.TrackViewer(r)
+
+	query := r.URL.Query()
+	if msnParam := query.Get("_HLS_msn"); msnParam != "" && path.Base(name) == "output.m3u8" {
+		msn, err := strconv.Atoi(msnParam)
+		if err != nil || msn < 0 {
+			http.Error(w, "Invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		part := -1
+		if partParam := query.Get("_HLS_part"); partParam != "" {
+			part, err = strconv.Atoi(partParam)
+			if err != nil || part < 0 {
+				http.Error(w, "Invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if playlist, ok := stream.WaitForLivePlaylist(path.Dir(name), msn, part); ok && playlist != nil {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write(playlist)
+			return
+		}
+	}
+
+	liveFiles.ServeHTTP(w, r)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils/upload"
+)
+
+// minBrowserTokenLength keeps browser tokens too long to guess
+const minBrowserTokenLength = 32
+
+// MyUploadsHandler lists the caller's uploads on GET and deletes one on DELETE ?id=.
+// Callers are identified like uploaders, by NIP-98 or the X-Browser-Token header.
+func MyUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, _, err := uploadOwner(r)
+	if err != nil {
+		http.Error(w, "Invalid authorization: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if owner == "" {
+		http.Error(w, "Sign the request with NIP-98 or send X-Browser-Token", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		records := upload.ListRecords(owner)
+		for i := range records {
+			records[i].URL = absoluteUploadURL(r, records[i].URL)
+			records[i].Thumb = absoluteUploadURL(r, records[i].Thumb)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	err = upload.DeleteRecord(r.Context(), owner, r.URL.Query().Get("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete upload: %v", err)
+		http.Error(w, "Failed to delete upload", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadOwner identifies who is making an upload request: the signer of a NIP-98
+// Authorization header, or the browser sending X-Browser-Token. Anonymous requests get "".
+// The NIP-98 authorization is returned too, its payload is checked once the body is read.
+func uploadOwner(r *http.Request) (string, nostr.HTTPAuth, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Nostr ") {
+		auth, err := nostr.VerifyHTTPAuth(header, r.Method, utils.PublicURL(r, r.URL.RequestURI()))
+		if err != nil {
+			return "", nostr.HTTPAuth{}, err
+		}
+		return upload.PubkeyOwner(auth.PubKey), auth, nil
+	}
+
+	if token := r.Header.Get("X-Browser-Token"); token != "" {
+		if len(token) < minBrowserTokenLength {
+			return "", nostr.HTTPAuth{}, errors.New("browser token is too short")
+		}
+		return upload.BrowserOwner(token), nostr.HTTPAuth{}, nil
+	}
+	return "", nostr.HTTPAuth{}, nil
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils/upload"
+)
+
+// NIP96APIPath is where the NIP-96 API is served, announced in /.well-known/nostr/nip96.json
+const NIP96APIPath = "/api/nip96"
+
+// nip96Info is the server description clients read from /.well-known/nostr/nip96.json
+type nip96Info struct {
+	APIURL        string               `json:"api_url"`
+	SupportedNIPs []int                `json:"supported_nips"`
+	ContentTypes  []string             `json:"content_types,omitempty"`
+	Plans         map[string]nip96Plan `json:"plans"`
+}
+
+type nip96Plan struct {
+	Name            string `json:"name"`
+	IsNIP98Required bool   `json:"is_nip98_required"`
+	URL             string `json:"url,omitempty"`
+	MaxByteSize     int64  `json:"max_byte_size"`
+	FileExpiration  [2]int `json:"file_expiration"` // Smallest and largest number of days files are kept, 0 for forever
+}
+
+// nip94Event is the unsigned kind 1063 event describing a stored file
+type nip94Event struct {
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	CreatedAt int64      `json:"created_at,omitempty"`
+}
+
+type nip96Response struct {
+	Status     string      `json:"status"`
+	Message    string      `json:"message"`
+	NIP94Event *nip94Event `json:"nip94_event,omitempty"`
+}
+
+type nip96List struct {
+	Count int          `json:"count"`
+	Total int          `json:"total"`
+	Page  int          `json:"page"`
+	Files []nip94Event `json:"files"`
+}
+
+// NIP96InfoHandler serves /.well-known/nostr/nip96.json so Nostr clients can use us as a media host
+func NIP96InfoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	days := utils.AppConfig.Upload.ExpireDays
+	info := nip96Info{
+		APIURL:        utils.PublicURL(r, NIP96APIPath),
+		SupportedNIPs: []int{94, 96, 98},
+		ContentTypes:  utils.AppConfig.Upload.Filter.AllowTypes,
+		Plans: map[string]nip96Plan{
+			"free": {
+				Name:            "Free",
+				IsNIP98Required: true,
+				MaxByteSize:     upload.FreeMaxSize(),
+				FileExpiration:  [2]int{days, days},
+			},
+		},
+	}
+	// Paid uploads need a quote from the upload page first
+	if upload.PaymentsEnabled() {
+		for i, tier := range upload.PriceTiers() {
+			info.Plans[fmt.Sprintf("tier-%d", i+1)] = nip96Plan{
+				Name:            fmt.Sprintf("Up to %d MB for %d days, %d sats", tier.MaxMB, tier.Days, tier.Sats),
+				IsNIP98Required: true,
+				URL:             utils.PublicURL(r, "/file-upload"),
+				MaxByteSize:     min(tier.MaxMB<<20, upload.MaxSize()),
+				FileExpiration:  [2]int{tier.Days, tier.Days},
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// NIP96Handler serves the NIP-96 API: POST uploads a file, GET ?page=&count= lists the
+// caller's files, GET /<sha256> redirects to a file and DELETE /<sha256> deletes one.
+// Everything but downloads has to be signed with NIP-98.
+func NIP96Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Payment-Token")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The hash may carry the file's extension
+	hash := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, NIP96APIPath), "/")
+	hash = strings.TrimSuffix(hash, path.Ext(hash))
+
+	switch {
+	case r.Method == http.MethodGet && hash != "":
+		record, err := upload.RecordByHash(hash)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, absoluteUploadURL(r, record.URL), http.StatusFound)
+		return
+	case r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete:
+		writeNIP96Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	case r.Method == http.MethodPost && hash != "":
+		writeNIP96Error(w, "Upload to "+NIP96APIPath, http.StatusNotFound)
+		return
+	}
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		writeNIP96Error(w, "Sign the request with NIP-98", http.StatusUnauthorized)
+		return
+	}
+	verified, err := nostr.VerifyHTTPAuth(auth, r.Method, utils.PublicURL(r, r.URL.RequestURI()))
+	if err != nil {
+		writeNIP96Error(w, "Invalid authorization: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	owner := upload.PubkeyOwner(verified.PubKey)
+
+	switch r.Method {
+	case http.MethodPost:
+		received, uploadErr := receiveUpload(w, r, owner, verified)
+		if uploadErr != nil {
+			writeNIP96Error(w, uploadErr.Message, uploadErr.Status)
+			return
+		}
+		event := nip94EventFor(r, received.Record)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(nip96Response{Status: "success", Message: "Upload successful.", NIP94Event: &event})
+	case http.MethodGet:
+		listNIP96Files(w, r, owner)
+	case http.MethodDelete:
+		deleteNIP96File(w, r, owner, hash)
+	}
+}
+
+// listNIP96Files lists the caller's files newest first, page counts from 0
+func listNIP96Files(w http.ResponseWriter, r *http.Request, owner string) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	page = max(page, 0)
+	if count <= 0 || count > 100 {
+		count = 10
+	}
+
+	records := upload.ListRecords(owner)
+	list := nip96List{Total: len(records), Page: page, Files: []nip94Event{}}
+	for i := page * count; i < len(records) && i < (page+1)*count; i++ {
+		list.Files = append(list.Files, nip94EventFor(r, records[i]))
+	}
+	list.Count = len(list.Files)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func deleteNIP96File(w http.ResponseWriter, r *http.Request, owner, hash string) {
+	if hash == "" {
+		writeNIP96Error(w, "Missing file hash", http.StatusBadRequest)
+		return
+	}
+
+	hash = strings.ToLower(hash)
+	for _, record := range upload.ListRecords(owner) {
+		if record.SHA256 != hash && record.OriginalSHA256 != hash {
+			continue
+		}
+		err := upload.DeleteRecord(r.Context(), owner, record.ID)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to delete upload: %v", err)
+			writeNIP96Error(w, "Failed to delete file", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nip96Response{Status: "success", Message: "File deleted."})
+		return
+	}
+	writeNIP96Error(w, "File not found", http.StatusNotFound)
+}
+
+// nip94EventFor describes a recorded upload with NIP-94 tags
+func nip94EventFor(r *http.Request, record upload.Record) nip94Event {
+	metadata := nostr.FileMetadata{
+		URL:      absoluteUploadURL(r, record.URL),
+		MIME:     record.ContentType,
+		SHA256:   record.SHA256,
+		Original: record.OriginalSHA256,
+		Size:     record.Size,
+		Dim:      record.Dim,
+		Blurhash: record.Blurhash,
+		Thumb:    absoluteUploadURL(r, record.Thumb),
+	}
+	// Files that weren't changed on the way in are their own original
+	if metadata.Original == "" {
+		metadata.Original = record.SHA256
+	}
+	return nip94Event{Tags: metadata.BuildTags(), CreatedAt: record.CreatedAt}
+}
+
+func writeNIP96Error(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(nip96Response{Status: "error", Message: message})
+}
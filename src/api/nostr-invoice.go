@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
@@ -19,24 +18,6 @@ import (
 	"github.com/btcsuite/btcutil/bech32"
 )
 
-var (
-	CLN_REST_URL string
-	RUNE_TOKEN   string
-)
-
-func init() {
-	// Default config path
-	configPath := "config.yml"
-
-	// Load config
-	if err := utils.LoadConfig(configPath); err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
-
-	CLN_REST_URL = utils.AppConfig.Lightning.CLNRestURL
-	RUNE_TOKEN = utils.AppConfig.Lightning.Rune
-}
-
 type InvoiceRequest struct {
 	Amount int64  `json:"amount_msat"`
 	Label  string `json:"label"`
@@ -64,7 +45,7 @@ func generateUniqueLabel() string {
 
 func CLNInvoice(sats int, name string) (string, string, error) {
 	label := generateUniqueLabel()
-	url := fmt.Sprintf("%s/v1/invoice", CLN_REST_URL)
+	url := fmt.Sprintf("%s/v1/invoice", utils.AppConfig.Lightning.CLNRestURL)
 
 	description := fmt.Sprintf("Payment for service from %s", name)
 	requestData := InvoiceRequest{
@@ -82,7 +63,7 @@ func CLNInvoice(sats int, name string) (string, string, error) {
 	if err != nil {
 		return "", "", fmt.Errorf("error creating request: %w", err)
 	}
-	req.Header.Set("Rune", RUNE_TOKEN)
+	req.Header.Set("Rune", utils.AppConfig.Lightning.Rune)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
@@ -116,7 +97,7 @@ func CLNInvoice(sats int, name string) (string, string, error) {
 
 func WaitForNostrInvoice(label, name, key string) {
 	fmt.Println("Waiting for invoice with label:", label)
-	url := fmt.Sprintf("%s/v1/waitinvoice", CLN_REST_URL)
+	url := fmt.Sprintf("%s/v1/waitinvoice", utils.AppConfig.Lightning.CLNRestURL)
 
 	payload := map[string]string{"label": label}
 	jsonPayload, err := json.Marshal(payload)
@@ -130,7 +111,7 @@ func WaitForNostrInvoice(label, name, key string) {
 		fmt.Println("Error creating request:", err)
 		return
 	}
-	req.Header.Set("Rune", RUNE_TOKEN)
+	req.Header.Set("Rune", utils.AppConfig.Lightning.Rune)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+// PastStreamsHandler lists archived recordings, newest first.
+// Supports ?page=, ?per_page=, ?q= to search titles and summaries, and ?tag=
+func PastStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	perPage, _ := strconv.Atoi(query.Get("per_page"))
+
+	result, err := stream.ListPastStreams(stream.PastStreamQuery{
+		Page:    page,
+		PerPage: perPage,
+		Search:  query.Get("q"),
+		Tag:     query.Get("tag"),
+	})
+	if err != nil {
+		http.Error(w, "Failed to read past streams", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// PinRequest is the body for pinning a past stream
+type PinRequest struct {
+	Folder string `json:"folder"`
+	Pinned bool   `json:"pinned"`
+}
+
+// PastStreamPinHandler lets admins exempt a recording from the retention policy
+func PastStreamPinHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !utils.IsAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req PinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := stream.SetPastStreamPinned(req.Folder, req.Pinned)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Past stream not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update past stream", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PastStreamStorageHandler reports archive usage, retention settings and recent storage failures to admins
+func PastStreamStorageHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.IsAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stream.GetStorageStatus())
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+// ModerationRequest is a moderator action on the live chat
+type ModerationRequest struct {
+	Action string `json:"action"` // "mute", "unmute", "hide" or "unhide"
+	Pubkey string `json:"pubkey,omitempty"`
+	ID     string `json:"id,omitempty"`
+}
+
+// StreamChatEventsHandler sends the chat history and then every new message over SSE
+func StreamChatEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set SSE Headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading history so no message falls in between
+	updates, unsubscribe := stream.SubscribeChat()
+	defer unsubscribe()
+
+	history, _ := json.Marshal(stream.ChatHistory())
+	fmt.Fprintf(w, "event: history\ndata: %s\n\n", history)
+	flusher.Flush()
+
+	for {
+		select {
+		case update := <-updates:
+			data, _ := json.Marshal(update)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", update.Type, data)
+			flusher.Flush()
+		case <-time.After(30 * time.Second): // Keep proxies from closing an idle connection
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// StreamChatModerationHandler lists moderation state on GET and applies moderator actions on POST
+func StreamChatModerationHandler(w http.ResponseWriter, r *http.Request) {
+	if !utils.IsAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req ModerationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		switch req.Action {
+		case "mute", "unmute":
+			err = stream.MuteChatPubkey(req.Pubkey, req.Action == "mute")
+		case "hide", "unhide":
+			err = stream.HideChatMessage(req.ID, req.Action == "hide")
+		default:
+			err = fmt.Errorf("unknown action %q", req.Action)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stream.GetChatModeration())
+}
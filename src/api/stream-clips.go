@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+// ClipRequest is the body for cutting a clip
+type ClipRequest struct {
+	Dtag  string  `json:"dtag"`
+	Start float64 `json:"start"` // Seconds from the start of the stream
+	End   float64 `json:"end"`
+	Title string  `json:"title"` // Optional, defaults to the stream title
+}
+
+// StreamClipsHandler lists clips on GET (optionally ?dtag=) and lets admins cut new ones on POST
+func StreamClipsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		clips, err := stream.ListClips(r.URL.Query().Get("dtag"))
+		if err != nil {
+			http.Error(w, "Failed to read clips", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(clips)
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !utils.IsAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ClipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	clip, err := stream.CreateClip(req.Dtag, req.Start, req.End, req.Title)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Stream not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, stream.ErrInvalidClip) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create clip: %v", err)
+		http.Error(w, "Failed to create clip", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(clip)
+}
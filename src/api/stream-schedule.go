@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+// ScheduleRequest is the body for creating or updating a scheduled stream
+type ScheduleRequest struct {
+	Title   string   `json:"title"`
+	Summary string   `json:"summary"`
+	Image   string   `json:"image"`
+	Tags    []string `json:"tags"`
+	Starts  int64    `json:"starts"` // Unix timestamp
+}
+
+// StreamScheduleHandler lists upcoming streams on GET and lets admins
+// create (POST), update (PUT ?dtag=) and cancel (DELETE ?dtag=) them
+func StreamScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		streams, err := stream.ListScheduledStreams()
+		if err != nil {
+			http.Error(w, "Failed to read scheduled streams", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(streams)
+		return
+	}
+
+	if !utils.IsAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dtag := r.URL.Query().Get("dtag")
+
+	var (
+		scheduled stream.ScheduledStream
+		err       error
+	)
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		scheduled = stream.ScheduledStream{
+			Title:   req.Title,
+			Summary: req.Summary,
+			Image:   req.Image,
+			Tags:    req.Tags,
+			Starts:  strconv.FormatInt(req.Starts, 10),
+		}
+
+		if r.Method == http.MethodPost {
+			scheduled, err = stream.CreateScheduledStream(scheduled)
+		} else {
+			scheduled, err = stream.UpdateScheduledStream(dtag, scheduled)
+		}
+	case http.MethodDelete:
+		err = stream.DeleteScheduledStream(dtag)
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Scheduled stream not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(scheduled)
+}
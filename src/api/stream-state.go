@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"goFrame/src/utils/stream"
+)
+
+// GetStreamState reports the current stream state and its transition history
+func GetStreamState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stream.GetStreamState())
+}
+
+// StreamStateEventsHandler pushes every stream state transition to the client over SSE
+func StreamStateEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Set SSE Headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	transitions, unsubscribe := stream.SubscribeStateChanges()
+	defer unsubscribe()
+
+	// Start with the current state so clients do not need a separate request
+	snapshot := stream.GetStreamState()
+	data, _ := json.Marshal(map[string]interface{}{"state": snapshot.State, "dtag": snapshot.Dtag, "since": snapshot.Since})
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", data)
+	flusher.Flush()
+
+	for {
+		select {
+		case t := <-transitions:
+			data, _ := json.Marshal(t)
+			fmt.Fprintf(w, "event: transition\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-time.After(30 * time.Second): // Keep proxies from closing an idle connection
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream"
+)
+
+// GetTranscoderStatus reports the state and progress of the HLS transcoder.
+// The PID and FFmpeg output can reveal input URLs and paths, so only admins see them.
+func GetTranscoderStatus(w http.ResponseWriter, r *http.Request) {
+	status := stream.GetTranscoderStatus()
+	if !utils.IsAdmin(r) {
+		status.PID = 0
+		status.RecentLog = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
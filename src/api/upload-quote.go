@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/upload"
+)
+
+// QuoteRequest asks for the price of an upload
+type QuoteRequest struct {
+	Size int64 `json:"size"` // Bytes
+	Days int   `json:"days"` // How long the file should be kept
+}
+
+// UploadPricing describes the upload paywall for clients
+type UploadPricing struct {
+	Enabled   bool              `json:"enabled"`
+	FreeMaxMB int64             `json:"free_max_mb"`
+	MaxMB     int64             `json:"max_mb"`
+	Tiers     []utils.PriceTier `json:"tiers"`
+}
+
+// UploadQuoteHandler returns the pricing on GET, or the state of a quote with ?payment_hash=.
+// POST {size, days} returns a quote with the invoice to pay, the paid invoice's payment hash
+// or preimage then goes in the X-Payment-Token header of the upload.
+func UploadQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if hash := r.URL.Query().Get("payment_hash"); hash != "" {
+			quote, err := upload.QuoteStatus(hash)
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "Quote not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to check upload quote: %v", err)
+				http.Error(w, "Failed to check payment", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(quote)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UploadPricing{
+			Enabled:   upload.PaymentsEnabled(),
+			FreeMaxMB: upload.FreeMaxSize() >> 20,
+			MaxMB:     upload.MaxSize() >> 20,
+			Tiers:     upload.PriceTiers(),
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	quote, err := upload.CreateQuote(req.Size, req.Days)
+	switch {
+	case errors.Is(err, upload.ErrTooLarge):
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, upload.ErrNoPrice):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to create upload quote: %v", err)
+		http.Error(w, "Failed to create invoice", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quote)
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"goFrame/src/lightning"
+	"goFrame/src/utils"
 )
 
 // LNURLpResponse represents the metadata returned from .well-known/lnurlp/{username}
@@ -32,7 +34,7 @@ func LNURLpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Construct callback URL where the wallet will request an invoice
-	callback := fmt.Sprintf("https://%s/lnurl/pay?username=%s", r.Host, username)
+	callback := utils.PublicURL(r, "/lnurl/pay?username="+url.QueryEscape(username))
 
 	// Define LNURLp metadata response with Nostr support
 	response := LNURLpResponse{
@@ -20,13 +20,17 @@ type InvoiceRequest struct {
 
 // InvoiceResponse represents the JSON response from CLN REST API
 type InvoiceResponse struct {
-	Bolt11 string `json:"bolt11"`
+	Bolt11      string `json:"bolt11"`
+	PaymentHash string `json:"payment_hash"`
+	ExpiresAt   int64  `json:"expires_at"`
 }
 
 // InvoiceResult contains both the invoice and the label used
 type InvoiceResult struct {
-	Bolt11 string
-	Label  string
+	Bolt11      string
+	Label       string
+	PaymentHash string
+	ExpiresAt   int64
 }
 
 // FetchInvoice requests an invoice from CLN REST and returns both invoice and label
@@ -90,7 +94,53 @@ func FetchInvoiceWithLabel(amountMsats int64, description string) (*InvoiceResul
 	}
 
 	return &InvoiceResult{
-		Bolt11: response.Bolt11,
-		Label:  label,
+		Bolt11:      response.Bolt11,
+		Label:       label,
+		PaymentHash: response.PaymentHash,
+		ExpiresAt:   response.ExpiresAt,
 	}, nil
 }
+
+// LookupInvoice returns the current state of an invoice without waiting for it to be paid
+func LookupInvoice(label string) (*WaitInvoiceResponse, error) {
+	restURL := utils.AppConfig.Lightning.CLNRestURL
+	runeToken := utils.AppConfig.Lightning.Rune
+
+	requestBody, err := json.Marshal(map[string]string{"label": label})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/v1/listinvoices", restURL), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Rune", runeToken)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("listinvoices failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		Invoices []WaitInvoiceResponse `json:"invoices"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w\nResponse: %s", err, string(body))
+	}
+	if len(response.Invoices) == 0 {
+		return nil, fmt.Errorf("no invoice with label %s", label)
+	}
+	return &response.Invoices[0], nil
+}
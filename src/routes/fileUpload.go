@@ -2,12 +2,24 @@ package routes
 
 import (
 	"goFrame/src/utils"
+	"goFrame/src/utils/upload"
 	"net/http"
 )
 
 func FileUpload(w http.ResponseWriter, r *http.Request) {
+	backend := "unconfigured"
+	if b, err := upload.ConfiguredBackend(); err == nil {
+		backend = b.Name()
+	}
+
 	data := utils.PageData{
 		Title: "File Upload",
+		CustomData: map[string]interface{}{
+			"Backend":   backend,
+			"MaxSizeMB": upload.MaxSize() >> 20,
+			"Payments":  upload.PaymentsEnabled(),
+			"FreeMaxMB": upload.FreeMaxSize() >> 20,
+		},
 	}
 
 	utils.RenderTemplate(w, data, "file-upload.html", false)
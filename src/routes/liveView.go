@@ -37,14 +37,14 @@ func LiveView(w http.ResponseWriter, r *http.Request) {
 	data := utils.PageData{
 		Title: "Live Stream Debug View",
 		CustomData: map[string]interface{}{
-			"StreamURL":    streamData["StreamURL"],
-			"Title":        streamData["Title"],
-			"Summary":      streamData["Summary"],
-			"Image":        streamData["Image"],
-			"Tags":         streamData["Tags"],
-			"Status":       streamData["Status"],
-			"Starts":       streamData["Starts"],
-			"RecordingURL": streamData["RecordingURL"],
+			"StreamURL":    streamData["stream_url"],
+			"Title":        streamData["title"],
+			"Summary":      streamData["summary"],
+			"Image":        streamData["image"],
+			"Tags":         streamData["tags"],
+			"Status":       streamData["status"],
+			"Starts":       streamData["starts"],
+			"RecordingURL": streamData["recording_url"],
 		},
 	}
 
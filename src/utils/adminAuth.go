@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// IsAdmin reports whether the request carries the configured admin bearer token
+func IsAdmin(r *http.Request) bool {
+	token := AppConfig.Server.AdminToken
+	if token == "" {
+		return false
+	}
+
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
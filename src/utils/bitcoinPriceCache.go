@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	btcCacheFile = "web/logs/last-btc-price.json"
+
+	// BitcoinPriceRefreshInterval is how often the background refresher asks the price sources
+	BitcoinPriceRefreshInterval = time.Minute
+	btcRefreshTimeout           = 15 * time.Second
+)
+
+// BitcoinPriceSnapshot is the last aggregated Bitcoin price in every currency, refreshed in the background
+type BitcoinPriceSnapshot struct {
+	Prices    map[string]CurrencyPrice `json:"prices"`
+	Timestamp time.Time                `json:"timestamp"` // Last successful refresh
+}
+
+// Age is how long ago a price was fetched
+func (p CurrencyPrice) Age() time.Duration {
+	return time.Since(p.UpdatedAt)
+}
+
+var btcPrice = struct {
+	sync.Mutex
+	snapshot *BitcoinPriceSnapshot
+	loaded   bool // The disk cache has been read
+}{}
+
+// RefreshBitcoinPrice asks the price sources and replaces the prices they agree on in
+// memory and on disk. Currencies a refresh couldn't price keep their previous price.
+// It reports whether the sources had a price.
+func RefreshBitcoinPrice() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), btcRefreshTimeout)
+	defer cancel()
+
+	prices, err := AggregateBitcoinPrice(ctx)
+	if err != nil {
+		fmt.Println("Error refreshing Bitcoin price:", err)
+		return false
+	}
+
+	// Loads the disk cache first so its prices can be carried over
+	previous, _ := currentBitcoinPrices()
+
+	btcPrice.Lock()
+	for currency, price := range previous.Prices {
+		if _, ok := prices[currency]; !ok {
+			prices[currency] = price
+		}
+	}
+	snapshot := BitcoinPriceSnapshot{Prices: prices, Timestamp: time.Now()}
+	btcPrice.snapshot = &snapshot
+	btcPrice.Unlock()
+
+	saveBitcoinPriceToCache(snapshot)
+	return true
+}
+
+// BitcoinPrice returns the latest price of one bitcoin in currency, falling back to the
+// one saved before a restart
+func BitcoinPrice(currency string) (CurrencyPrice, bool) {
+	snapshot, ok := currentBitcoinPrices()
+	if !ok {
+		return CurrencyPrice{}, false
+	}
+	price, ok := snapshot.Prices[strings.ToUpper(currency)]
+	return price, ok
+}
+
+func currentBitcoinPrices() (BitcoinPriceSnapshot, bool) {
+	btcPrice.Lock()
+	defer btcPrice.Unlock()
+
+	if !btcPrice.loaded {
+		btcPrice.loaded = true
+		if snapshot, err := loadBitcoinPriceFromCache(); err == nil {
+			btcPrice.snapshot = snapshot
+		}
+	}
+	if btcPrice.snapshot == nil {
+		return BitcoinPriceSnapshot{}, false
+	}
+	return *btcPrice.snapshot, true
+}
+
+func loadBitcoinPriceFromCache() (*BitcoinPriceSnapshot, error) {
+	data, err := os.ReadFile(btcCacheFile)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot BitcoinPriceSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	// Caches written before prices were kept per currency have none
+	if len(snapshot.Prices) == 0 {
+		return nil, fmt.Errorf("no prices in %s", btcCacheFile)
+	}
+	return &snapshot, nil
+}
+
+func saveBitcoinPriceToCache(snapshot BitcoinPriceSnapshot) {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		fmt.Println("Failed to save Bitcoin price cache:", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(btcCacheFile), 0755); err != nil {
+		fmt.Println("Failed to save Bitcoin price cache:", err)
+		return
+	}
+	// Written aside and renamed so a crash never leaves half a file
+	tmp := btcCacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		fmt.Println("Failed to save Bitcoin price cache:", err)
+		return
+	}
+	if err := os.Rename(tmp, btcCacheFile); err != nil {
+		fmt.Println("Failed to save Bitcoin price cache:", err)
+	}
+}
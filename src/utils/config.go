@@ -9,8 +9,12 @@ import (
 
 // ServerConfig holds server-related configurations
 type ServerConfig struct {
-	Port int  `yaml:"port"`
-	TLS  bool `yaml:"tls"`
+	Port           int      `yaml:"port"`
+	TLS            bool     `yaml:"tls"`
+	PublicURL      string   `yaml:"public_url"`      // Base for absolute links, e.g. "https://example.com"
+	MediaURL       string   `yaml:"media_url"`       // Optional CDN base for live and recorded media
+	AdminToken     string   `yaml:"admin_token"`     // Bearer token for moderator and admin APIs, empty disables them
+	TrustedProxies []string `yaml:"trusted_proxies"` // Reverse proxies whose X-Forwarded-For is believed, addresses or CIDR ranges
 }
 
 // LightningConfig holds settings for the Lightning backend (LND, CLN, or Eclair)
@@ -22,10 +26,81 @@ type LightningConfig struct {
 	ZapRelays  []string `yaml:"zap_relays"`   // Relays to publish zap receipts to
 }
 
+// PricesConfig holds settings for the Bitcoin price feeds behind /api/btc-price
+type PricesConfig struct {
+	CoinMarketCapKey string   `yaml:"coinmarketcap_key"` // CoinMarketCap is skipped without one
+	MaxDeviation     float64  `yaml:"max_deviation"`     // Quotes further than this fraction from the median are dropped, defaults to 0.02
+	Currencies       []string `yaml:"currencies"`        // Fiat currencies to price Bitcoin in, USD is always included
+}
+
+// UploadConfig holds settings for /api/file-upload
+type UploadConfig struct {
+	Backend    string              `yaml:"backend"`     // "local", "blossom", "0x0" or "s3", defaults to "0x0"
+	MaxSizeMB  int64               `yaml:"max_size_mb"` // Largest accepted file, defaults to 512
+	ExpireDays int                 `yaml:"expire_days"` // Free uploads are deleted after this many days, 0 keeps them
+	Local      LocalUploadConfig   `yaml:"local"`
+	Blossom    BlossomUploadConfig `yaml:"blossom"`
+	ZeroX0     ZeroX0UploadConfig  `yaml:"0x0"`
+	S3         S3UploadConfig      `yaml:"s3"`
+	Payment    UploadPaymentConfig `yaml:"payment"`
+	Filter     UploadFilterConfig  `yaml:"filter"`
+}
+
+// UploadFilterConfig decides what may be uploaded. Executables and files that are
+// valid in two formats at once are always refused.
+type UploadFilterConfig struct {
+	AllowTypes []string `yaml:"allow_types"` // MIME types such as "image/*", empty allows anything that isn't denied
+	DenyTypes  []string `yaml:"deny_types"`
+	Blocklist  string   `yaml:"blocklist"` // SHA-256 hashes to refuse, one per line, defaults to "data/upload-blocklist.txt"
+	Clamd      string   `yaml:"clamd"`     // clamd socket path or host:port, empty skips virus scanning
+}
+
+// UploadPaymentConfig puts uploads behind a Lightning paywall
+type UploadPaymentConfig struct {
+	Enabled   bool        `yaml:"enabled"`
+	FreeMaxMB int64       `yaml:"free_max_mb"` // Uploads up to this size stay free, 0 charges for everything
+	Tiers     []PriceTier `yaml:"tiers"`
+}
+
+// PriceTier is the price of keeping files up to a size for up to a number of days
+type PriceTier struct {
+	MaxMB int64 `yaml:"max_mb" json:"max_mb"`
+	Days  int   `yaml:"days" json:"days"`
+	Sats  int64 `yaml:"sats" json:"sats"`
+}
+
+// LocalUploadConfig stores uploads on this server's disk
+type LocalUploadConfig struct {
+	Dir string `yaml:"dir"` // Defaults to "data/uploads", served under /uploads/
+}
+
+// BlossomUploadConfig stores uploads on Blossom servers, tried in order
+type BlossomUploadConfig struct {
+	Servers []string `yaml:"servers"`
+}
+
+// ZeroX0UploadConfig stores uploads on a 0x0-compatible host
+type ZeroX0UploadConfig struct {
+	URL string `yaml:"url"` // Defaults to "https://0x0.st"
+}
+
+// S3UploadConfig stores uploads in an S3-compatible bucket
+type S3UploadConfig struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. "https://s3.us-east-1.amazonaws.com" or "http://localhost:9000"
+	Region    string `yaml:"region"`   // Defaults to "us-east-1"
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"` // Use endpoint/bucket/key instead of bucket.endpoint/key, needed by most self-hosted stores
+	PublicURL string `yaml:"public_url"` // Base for object links, e.g. a CDN, defaults to the bucket URL
+}
+
 // Config holds the full application configuration
 type Config struct {
 	Server    ServerConfig    `yaml:"server"`
 	Lightning LightningConfig `yaml:"lightning"`
+	Upload    UploadConfig    `yaml:"upload"`
+	Prices    PricesConfig    `yaml:"prices"`
 }
 
 // Global variable to hold the config after loading
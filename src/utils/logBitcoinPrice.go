@@ -4,7 +4,6 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,25 +11,14 @@ import (
 	"time"
 )
 
-type PriceResponse struct {
-	Price string `json:"Price"`
-}
+// staleBitcoinPrice is the age past which a price is too old to log as the current one
+const staleBitcoinPrice = 10 * time.Minute
 
+// LogBitcoinPrice appends the current USD price from the background refresher to the price log
 func LogBitcoinPrice() {
-	// Default config path
-	configPath := "config.yml"
-
 	logFilePath := "web/logs/btc-price-log.csv"
 	blockHeightURL := "https://mempool.happytavern.co/api/blocks/tip/height"
 
-	// Load config
-	if err := LoadConfig(configPath); err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
-
-	// Construct API URL using port from config
-	apiURL := fmt.Sprintf("http://localhost:%d/api/btc-price", AppConfig.Server.Port)
-
 	// Ensure directory and file exist
 	if err := ensureFileExists(logFilePath); err != nil {
 		fmt.Println("Error ensuring file exists:", err)
@@ -38,24 +26,12 @@ func LogBitcoinPrice() {
 	}
 
 	// Get Bitcoin price
-	priceResponse, err := http.Get(apiURL)
-	if err != nil {
-		fmt.Println("Error fetching price:", err)
-		return
-	}
-	defer priceResponse.Body.Close()
-
-	if priceResponse.StatusCode != http.StatusOK {
-		fmt.Println("Error fetching price, status code:", priceResponse.StatusCode)
-		return
-	}
-
-	var priceData PriceResponse
-	if err := json.NewDecoder(priceResponse.Body).Decode(&priceData); err != nil {
-		fmt.Println("Error decoding price JSON:", err)
+	usd, ok := BitcoinPrice("USD")
+	if !ok || usd.Age() > staleBitcoinPrice {
+		fmt.Println("Error logging price: no recent Bitcoin price")
 		return
 	}
-	price := priceData.Price
+	price := fmt.Sprintf("%.2f", usd.Price)
 
 	// Get block height
 	blockHeightResponse, err := http.Get(blockHeightURL)
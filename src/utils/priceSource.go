@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	priceSourceTimeout  = 5 * time.Second
+	defaultMaxDeviation = 0.02
+
+	// Sources failing this many times in a row rest before they are asked again,
+	// twice as long after every further failure. A source that is off in one currency
+	// rests in that currency only.
+	unhealthyAfter   = 3
+	maxSourceBackoff = 30 * time.Minute
+)
+
+// defaultCurrencies are priced when prices.currencies is empty
+var defaultCurrencies = []string{"USD", "EUR", "GBP", "CAD", "JPY", "AUD", "CHF", "CNY"}
+
+// PriceSource is an exchange or index quoting the Bitcoin price
+type PriceSource interface {
+	Name() string
+	// FetchPrices returns the price of one bitcoin in as many of the currencies as the source quotes
+	FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error)
+}
+
+// SourceQuote is what one source had to do with an aggregated price
+type SourceQuote struct {
+	Name   string  `json:"name"`
+	Price  float64 `json:"price,omitempty"`
+	Reason string  `json:"reason,omitempty"` // Why the source was left out
+}
+
+// CurrencyPrice is the median of the quotes in one currency that agree with each other
+type CurrencyPrice struct {
+	Price     float64       `json:"price"`
+	Sources   []SourceQuote `json:"sources"`            // Quotes the price was made from
+	Excluded  []SourceQuote `json:"excluded,omitempty"` // Sources that failed, are resting or were too far off
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// sourceHealth tracks how a source has been answering lately, as a whole or in one currency
+type sourceHealth struct {
+	failures  int // In a row
+	lastError string
+	retryAt   time.Time // Zero while the source is healthy
+}
+
+var priceSources = struct {
+	sync.Mutex
+	health map[string]*sourceHealth // By source name, and by quoteKey for single currencies
+}{health: map[string]*sourceHealth{}}
+
+// quoteKey names a source's quotes in one currency
+func quoteKey(name, currency string) string {
+	return name + " " + currency
+}
+
+// BitcoinPriceSources returns the price sources in use
+func BitcoinPriceSources() []PriceSource {
+	sources := []PriceSource{coingeckoSource{}, blockchainInfoSource{}, coinbaseSource{}}
+	if key := AppConfig.Prices.CoinMarketCapKey; key != "" {
+		sources = append(sources, coinmarketcapSource{key: key})
+	}
+	return sources
+}
+
+// PriceCurrencies returns the currency codes the Bitcoin price is kept in, USD first
+func PriceCurrencies() []string {
+	configured := AppConfig.Prices.Currencies
+	if len(configured) == 0 {
+		configured = defaultCurrencies
+	}
+	currencies := []string{"USD"}
+	for _, c := range configured {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if c != "" && !slices.Contains(currencies, c) {
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies
+}
+
+// AggregateBitcoinPrice asks every healthy source at once and returns, per currency, the
+// median of the quotes, leaving out those too far from the others. Currencies no source
+// could price are missing from the result.
+func AggregateBitcoinPrice(ctx context.Context) (map[string]CurrencyPrice, error) {
+	return aggregatePrices(ctx, BitcoinPriceSources(), PriceCurrencies())
+}
+
+func aggregatePrices(ctx context.Context, sources []PriceSource, currencies []string) (map[string]CurrencyPrice, error) {
+	type answer struct {
+		name   string
+		prices map[string]float64
+		err    error
+	}
+
+	// Sources that didn't answer are left out of every currency
+	var skipped []SourceQuote
+	answers := make(chan answer, len(sources))
+	asked := 0
+	for _, source := range sources {
+		if retryAt := sourceRetryAt(source.Name()); time.Now().Before(retryAt) {
+			skipped = append(skipped, SourceQuote{
+				Name:   source.Name(),
+				Reason: fmt.Sprintf("failing, next try in %s", time.Until(retryAt).Round(time.Second)),
+			})
+			continue
+		}
+		asked++
+		go func(source PriceSource) {
+			ctx, cancel := context.WithTimeout(ctx, priceSourceTimeout)
+			defer cancel()
+			prices, err := source.FetchPrices(ctx, currencies)
+			answers <- answer{source.Name(), prices, err}
+		}(source)
+	}
+
+	quotes := make(map[string][]SourceQuote)
+	resting := make(map[string][]SourceQuote) // Quotes left out because the source was off in that currency
+	var answered []string
+	for range asked {
+		a := <-answers
+		valid := 0
+		for _, currency := range currencies {
+			price, ok := a.prices[currency]
+			if a.err != nil || !ok || price <= 0 || math.IsInf(price, 0) || math.IsNaN(price) {
+				continue
+			}
+			valid++
+			if retryAt := sourceRetryAt(quoteKey(a.name, currency)); time.Now().Before(retryAt) {
+				resting[currency] = append(resting[currency], SourceQuote{
+					Name:   a.name,
+					Price:  price,
+					Reason: fmt.Sprintf("off in %s, next try in %s", currency, time.Until(retryAt).Round(time.Second)),
+				})
+				continue
+			}
+			quotes[currency] = append(quotes[currency], SourceQuote{Name: a.name, Price: price})
+		}
+		if valid == 0 {
+			err := a.err
+			if err == nil {
+				err = errors.New("no valid prices")
+			}
+			sourceFailed(a.name, err)
+			skipped = append(skipped, SourceQuote{Name: a.name, Reason: err.Error()})
+			continue
+		}
+		answered = append(answered, a.name)
+		sourceAnswered(a.name)
+	}
+	if len(answered) == 0 {
+		return nil, errors.New("no price source answered")
+	}
+
+	maxDeviation := AppConfig.Prices.MaxDeviation
+	if maxDeviation <= 0 {
+		maxDeviation = defaultMaxDeviation
+	}
+	prices := make(map[string]CurrencyPrice)
+	now := time.Now()
+	for _, currency := range currencies {
+		price := rejectOutliers(currency, quotes[currency], maxDeviation)
+		// Being off in one currency doesn't make a source's other quotes wrong
+		for _, q := range price.Excluded {
+			sourceFailed(quoteKey(q.Name, currency), errors.New(q.Reason))
+		}
+		for _, q := range price.Sources {
+			sourceAnswered(quoteKey(q.Name, currency))
+		}
+		if len(price.Sources) == 0 {
+			continue
+		}
+		price.Price = median(price.Sources)
+		price.Excluded = append(price.Excluded, resting[currency]...)
+		price.Excluded = append(price.Excluded, skipped...)
+		price.UpdatedAt = now
+		sortQuotes(price.Sources)
+		sortQuotes(price.Excluded)
+		prices[currency] = price
+	}
+	if len(prices) == 0 {
+		return nil, errors.New("the price sources disagree")
+	}
+	return prices, nil
+}
+
+// rejectOutliers splits one currency's quotes into those close enough to the median and those too far off
+func rejectOutliers(currency string, quotes []SourceQuote, maxDeviation float64) CurrencyPrice {
+	var price CurrencyPrice
+	if len(quotes) == 0 {
+		return price
+	}
+	mid := median(quotes)
+	if len(quotes) == 2 && math.Abs(quotes[0].Price-quotes[1].Price)/mid > maxDeviation {
+		log.Printf("Price sources %s and %s disagree on %s and there is no third to settle it", quotes[0].Name, quotes[1].Name, currency)
+	}
+	for _, q := range quotes {
+		// With fewer than three quotes there is no majority to tell which one is off
+		if deviation := math.Abs(q.Price-mid) / mid; len(quotes) >= 3 && deviation > maxDeviation {
+			q.Reason = fmt.Sprintf("%.1f%% away from the median", deviation*100)
+			price.Excluded = append(price.Excluded, q)
+			continue
+		}
+		price.Sources = append(price.Sources, q)
+	}
+	return price
+}
+
+func median(quotes []SourceQuote) float64 {
+	prices := make([]float64, len(quotes))
+	for i, q := range quotes {
+		prices[i] = q.Price
+	}
+	sort.Float64s(prices)
+	mid := len(prices) / 2
+	if len(prices)%2 == 0 {
+		return (prices[mid-1] + prices[mid]) / 2
+	}
+	return prices[mid]
+}
+
+func sortQuotes(quotes []SourceQuote) {
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].Name < quotes[j].Name })
+}
+
+func sourceRetryAt(name string) time.Time {
+	priceSources.Lock()
+	defer priceSources.Unlock()
+	if h, ok := priceSources.health[name]; ok {
+		return h.retryAt
+	}
+	return time.Time{}
+}
+
+func sourceAnswered(name string) {
+	priceSources.Lock()
+	defer priceSources.Unlock()
+	if h, ok := priceSources.health[name]; ok && h.failures >= unhealthyAfter {
+		log.Printf("Price source %s is back", name)
+	}
+	priceSources.health[name] = &sourceHealth{}
+}
+
+func sourceFailed(name string, err error) {
+	priceSources.Lock()
+	defer priceSources.Unlock()
+	h, ok := priceSources.health[name]
+	if !ok {
+		h = &sourceHealth{}
+		priceSources.health[name] = h
+	}
+	h.failures++
+	h.lastError = err.Error()
+	if h.failures >= unhealthyAfter {
+		backoff := min(time.Minute<<min(h.failures-unhealthyAfter, 10), maxSourceBackoff)
+		h.retryAt = time.Now().Add(backoff)
+		log.Printf("Price source %s failed %d times in a row, resting for %s: %v", name, h.failures, backoff, err)
+	}
+}
+
+// getPriceJSON fetches a price feed and decodes its JSON body into v
+func getPriceJSON(ctx context.Context, url string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+type coingeckoSource struct{}
+
+func (coingeckoSource) Name() string { return "coingecko" }
+
+func (coingeckoSource) FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var data struct {
+		Bitcoin map[string]float64 `json:"bitcoin"`
+	}
+	url := "https://api.coingecko.com/api/v3/simple/price?ids=bitcoin&vs_currencies=" + strings.ToLower(strings.Join(currencies, ","))
+	if err := getPriceJSON(ctx, url, nil, &data); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]float64)
+	for currency, price := range data.Bitcoin {
+		prices[strings.ToUpper(currency)] = price
+	}
+	return prices, nil
+}
+
+// coinmarketcapSource only quotes USD, every further currency costs another API credit
+type coinmarketcapSource struct {
+	key string
+}
+
+func (coinmarketcapSource) Name() string { return "coinmarketcap" }
+
+func (s coinmarketcapSource) FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var data struct {
+		Data map[string]struct {
+			Quote map[string]struct {
+				Price float64 `json:"price"`
+			} `json:"quote"`
+		} `json:"data"`
+	}
+	header := http.Header{"X-CMC_PRO_API_KEY": {s.key}}
+	if err := getPriceJSON(ctx, "https://pro-api.coinmarketcap.com/v1/cryptocurrency/quotes/latest?id=1&convert=USD", header, &data); err != nil {
+		return nil, err
+	}
+	return map[string]float64{"USD": data.Data["1"].Quote["USD"].Price}, nil
+}
+
+type blockchainInfoSource struct{}
+
+func (blockchainInfoSource) Name() string { return "blockchain.info" }
+
+func (blockchainInfoSource) FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var data map[string]struct {
+		Last float64 `json:"last"`
+	}
+	if err := getPriceJSON(ctx, "https://blockchain.info/ticker", nil, &data); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]float64)
+	for currency, ticker := range data {
+		prices[currency] = ticker.Last
+	}
+	return prices, nil
+}
+
+type coinbaseSource struct{}
+
+func (coinbaseSource) Name() string { return "coinbase" }
+
+func (coinbaseSource) FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error) {
+	var data struct {
+		Data struct {
+			Rates map[string]string `json:"rates"`
+		} `json:"data"`
+	}
+	if err := getPriceJSON(ctx, "https://api.coinbase.com/v2/exchange-rates?currency=BTC", nil, &data); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]float64)
+	for _, currency := range currencies {
+		if rate, ok := data.Data.Rates[currency]; ok {
+			if price, err := strconv.ParseFloat(rate, 64); err == nil {
+				prices[currency] = price
+			}
+		}
+	}
+	return prices, nil
+}
@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// fakeSource quotes fixed prices, or fails with err
+type fakeSource struct {
+	name   string
+	prices map[string]float64
+	err    error
+}
+
+func (s fakeSource) Name() string { return s.name }
+
+func (s fakeSource) FetchPrices(ctx context.Context, currencies []string) (map[string]float64, error) {
+	return s.prices, s.err
+}
+
+// resetSourceHealth starts every test with healthy sources and the default deviation
+func resetSourceHealth(t *testing.T) {
+	saved := AppConfig.Prices
+	t.Cleanup(func() { AppConfig.Prices = saved })
+	AppConfig.Prices.MaxDeviation = 0
+
+	priceSources.Lock()
+	defer priceSources.Unlock()
+	clear(priceSources.health)
+}
+
+func quotesOf(prices ...float64) []SourceQuote {
+	quotes := make([]SourceQuote, len(prices))
+	for i, price := range prices {
+		quotes[i] = SourceQuote{Name: string(rune('a' + i)), Price: price}
+	}
+	return quotes
+}
+
+func names(quotes []SourceQuote) string {
+	var list []string
+	for _, q := range quotes {
+		list = append(list, q.Name)
+	}
+	return strings.Join(list, ",")
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []float64
+		want   float64
+	}{
+		{"one", []float64{100}, 100},
+		{"odd", []float64{300, 100, 200}, 200},
+		{"even", []float64{400, 100, 300, 200}, 250},
+		{"two", []float64{100, 101}, 100.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := median(quotesOf(tt.prices...)); got != tt.want {
+				t.Errorf("median = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectOutliers(t *testing.T) {
+	tests := []struct {
+		name     string
+		prices   []float64
+		sources  string
+		excluded string
+	}{
+		{"none", nil, "", ""},
+		{"all agree", []float64{100, 101, 99}, "a,b,c", ""},
+		{"one off", []float64{100, 101, 120}, "a,b", "c"},
+		{"two can't outvote each other", []float64{100, 200}, "a,b", ""},
+		{"just inside the limit", []float64{100, 100, 102}, "a,b,c", ""},
+		{"one of four off", []float64{100, 100.5, 99.5, 50}, "a,b,c", "d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price := rejectOutliers("USD", quotesOf(tt.prices...), 0.02)
+			if got := names(price.Sources); got != tt.sources {
+				t.Errorf("sources = %q, want %q", got, tt.sources)
+			}
+			if got := names(price.Excluded); got != tt.excluded {
+				t.Errorf("excluded = %q, want %q", got, tt.excluded)
+			}
+			for _, q := range price.Excluded {
+				if q.Reason == "" {
+					t.Errorf("%s excluded without a reason", q.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestAggregatePrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []PriceSource
+		want    map[string]float64 // Missing currencies must not be priced
+		wantErr bool
+	}{
+		{
+			name: "median of agreeing sources",
+			sources: []PriceSource{
+				fakeSource{name: "a", prices: map[string]float64{"USD": 100, "EUR": 90}},
+				fakeSource{name: "b", prices: map[string]float64{"USD": 101, "EUR": 91}},
+				fakeSource{name: "c", prices: map[string]float64{"USD": 102, "EUR": 92}},
+			},
+			want: map[string]float64{"USD": 101, "EUR": 91},
+		},
+		{
+			name: "outlier left out of its currency only",
+			sources: []PriceSource{
+				fakeSource{name: "a", prices: map[string]float64{"USD": 100, "EUR": 90}},
+				fakeSource{name: "b", prices: map[string]float64{"USD": 100, "EUR": 90}},
+				fakeSource{name: "c", prices: map[string]float64{"USD": 100, "EUR": 150}},
+			},
+			want: map[string]float64{"USD": 100, "EUR": 90},
+		},
+		{
+			name: "failing and invalid sources ignored",
+			sources: []PriceSource{
+				fakeSource{name: "a", prices: map[string]float64{"USD": 100}},
+				fakeSource{name: "b", err: errors.New("timeout")},
+				fakeSource{name: "c", prices: map[string]float64{"USD": math.NaN(), "EUR": -1}},
+			},
+			want: map[string]float64{"USD": 100},
+		},
+		{
+			name: "currency nobody quotes is missing",
+			sources: []PriceSource{
+				fakeSource{name: "a", prices: map[string]float64{"USD": 100}},
+			},
+			want: map[string]float64{"USD": 100},
+		},
+		{
+			name: "no source answers",
+			sources: []PriceSource{
+				fakeSource{name: "a", err: errors.New("down")},
+				fakeSource{name: "b", prices: map[string]float64{}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSourceHealth(t)
+			prices, err := aggregatePrices(context.Background(), tt.sources, []string{"USD", "EUR"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("aggregated %v without an error", prices)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("aggregatePrices: %v", err)
+			}
+			if len(prices) != len(tt.want) {
+				t.Errorf("priced %d currencies, want %d", len(prices), len(tt.want))
+			}
+			for currency, want := range tt.want {
+				if got := prices[currency].Price; got != want {
+					t.Errorf("%s = %v, want %v", currency, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutlierRestsInOneCurrency(t *testing.T) {
+	resetSourceHealth(t)
+	sources := []PriceSource{
+		fakeSource{name: "a", prices: map[string]float64{"USD": 100, "EUR": 90}},
+		fakeSource{name: "b", prices: map[string]float64{"USD": 100, "EUR": 90}},
+		fakeSource{name: "c", prices: map[string]float64{"USD": 100, "EUR": 150}},
+	}
+
+	// Off in EUR often enough to rest there
+	for range unhealthyAfter {
+		if _, err := aggregatePrices(context.Background(), sources, []string{"USD", "EUR"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prices, err := aggregatePrices(context.Background(), sources, []string{"USD", "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(prices["USD"].Sources); got != "a,b,c" {
+		t.Errorf("USD sources = %q, want every source", got)
+	}
+	if got := names(prices["EUR"].Sources); got != "a,b" {
+		t.Errorf("EUR sources = %q, want a,b", got)
+	}
+	excluded := prices["EUR"].Excluded
+	if len(excluded) != 1 || !strings.Contains(excluded[0].Reason, "next try") {
+		t.Errorf("EUR excluded = %+v, want c resting", excluded)
+	}
+}
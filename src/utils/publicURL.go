@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PublicURL returns an absolute URL for a path on this site. It uses server.public_url
+// when set and otherwise falls back to the request's host, r may be nil outside a request.
+func PublicURL(r *http.Request, path string) string {
+	return joinURL(baseURL(r), path)
+}
+
+// MediaURL returns an absolute URL for live and recorded media, served from
+// server.media_url when a CDN is configured
+func MediaURL(path string) string {
+	if AppConfig.Server.MediaURL != "" {
+		return joinURL(AppConfig.Server.MediaURL, path)
+	}
+	return PublicURL(nil, path)
+}
+
+func baseURL(r *http.Request) string {
+	if AppConfig.Server.PublicURL != "" {
+		return AppConfig.Server.PublicURL
+	}
+
+	scheme := "http"
+	if AppConfig.Server.TLS {
+		scheme = "https"
+	}
+
+	if r == nil {
+		return fmt.Sprintf("%s://localhost:%d", scheme, AppConfig.Server.Port)
+	}
+
+	// Behind a reverse proxy the forwarded scheme is the one clients see
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	} else if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, r.Host)
+}
+
+func joinURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
@@ -0,0 +1,235 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recordingMP4Name = "recording.mp4"
+	remuxTimeout     = 2 * time.Hour
+	janitorInterval  = time.Hour
+)
+
+// remuxMutex runs one remux at a time so a backlog of recordings doesn't saturate the disk
+var remuxMutex sync.Mutex
+
+// startArchiveJobs remuxes and mirrors recordings that missed it, then runs the janitor:
+// failed archives and Blossom uploads are retried and the retention policy is enforced
+func startArchiveJobs() {
+	go func() {
+		for _, folder := range archiveFolders() {
+			manifest, err := readManifest(folder)
+			if err != nil {
+				if manifest, err = writeManifest(folder); err != nil {
+					continue
+				}
+			}
+			if streamConfig.Archive.RemuxMP4 && manifest.MP4 == "" && !manifest.SegmentsDeleted {
+				remuxArchive(folder)
+			}
+			publishToBlossom(folder)
+		}
+
+		for {
+			retryPendingArchive(false)
+			retryBlossomMirrors()
+			if streamConfig.Archive.SegmentRetention > 0 {
+				pruneSegments()
+			}
+			enforceRetention()
+			time.Sleep(janitorInterval)
+		}
+	}()
+}
+
+// remuxArchive copies a recording's best rendition into a faststart MP4 and records its hash
+func remuxArchive(folder string) {
+	remuxMutex.Lock()
+	defer remuxMutex.Unlock()
+
+	// Another job may have remuxed it while this one waited
+	if manifest, err := readManifest(folder); err == nil && manifest.MP4 != "" {
+		return
+	}
+
+	playlist, _, err := recordingPlaylist(folder)
+	if err != nil {
+		log.Printf("No playlist to remux in %s: %v", folder, err)
+		return
+	}
+
+	log.Printf("Remuxing %s to MP4...", folder)
+	target := filepath.Join(folder, recordingMP4Name)
+	tmp := target + ".tmp"
+
+	ctx, cancel := context.WithTimeout(context.Background(), remuxTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-loglevel", "error",
+		"-i", playlist,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		tmp,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		reportStorageIssue("remux", folder, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output))))
+		return
+	}
+
+	sum, size, err := hashFile(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		log.Printf("Failed to hash remuxed %s: %v", folder, err)
+		return
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		reportStorageIssue("remux", folder, err)
+		return
+	}
+
+	err = updateManifest(folder, func(m *PastStream) {
+		m.MP4 = recordingMP4Name
+		m.MP4Size = size
+		m.SHA256 = sum
+		m.Size = folderSize(folder)
+	})
+	if err != nil {
+		reportStorageIssue("manifest", folder, err)
+		return
+	}
+	log.Printf("Remuxed %s (%d bytes, sha256 %s)", folder, size, sum)
+}
+
+// pruneSegments deletes the HLS segments of recordings whose MP4 is older than the retention period
+func pruneSegments() {
+	cutoff := time.Now().AddDate(0, 0, -streamConfig.Archive.SegmentRetention)
+
+	for _, folder := range archiveFolders() {
+		manifest, err := readManifest(folder)
+		if err != nil || manifest.MP4 == "" || manifest.SegmentsDeleted {
+			continue
+		}
+		if !archivedAt(folder, manifest).Before(cutoff) {
+			continue
+		}
+		// Never leave a recording with nothing to play
+		if _, err := os.Stat(filepath.Join(folder, manifest.MP4)); err != nil {
+			continue
+		}
+
+		if err := deleteSegments(folder); err != nil {
+			reportStorageIssue("prune segments", folder, err)
+			continue
+		}
+		err = updateManifest(folder, func(m *PastStream) {
+			m.SegmentsDeleted = true
+			m.Playlist = ""
+			m.Size = folderSize(folder)
+		})
+		if err != nil {
+			log.Printf("Failed to update manifest for %s: %v", folder, err)
+			continue
+		}
+		log.Printf("Deleted HLS segments of %s, the MP4 is kept", folder)
+	}
+}
+
+// deleteSegments removes playlists, segments and the then empty rendition directories
+func deleteSegments(folder string) error {
+	var dirs []string
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != folder {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".ts", ".m3u8", ".m4s":
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deepest first, non-empty directories are left alone
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return nil
+}
+
+// updateManifest applies a change to a recording's manifest on disk
+func updateManifest(folder string, change func(*PastStream)) error {
+	defer invalidatePastStreams()
+
+	manifest, err := readManifest(folder)
+	if err != nil {
+		return err
+	}
+	change(&manifest)
+	return saveManifest(folder, manifest)
+}
+
+// archiveFolders lists every recording folder in past streams
+func archiveFolders() []string {
+	entries, err := os.ReadDir(pastStreamsDir)
+	if err != nil {
+		return nil
+	}
+	var folders []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folders = append(folders, filepath.Join(pastStreamsDir, entry.Name()))
+		}
+	}
+	return folders
+}
+
+// archivedAt is when the recording ended, or when its folder was last touched
+func archivedAt(folder string, manifest PastStream) time.Time {
+	if ends, err := strconv.ParseInt(manifest.Ends, 10, 64); err == nil && ends > 0 {
+		return time.Unix(ends, 0)
+	}
+	if info, err := os.Stat(folder); err == nil {
+		return info.ModTime()
+	}
+	return time.Now()
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
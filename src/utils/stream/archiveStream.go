@@ -1,71 +1,90 @@
 package stream
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"time"
 )
 
-// archiveStream archives the stream segments to a permanent location
-func archiveStream() {
-	log.Println("archiveStream: Archiving existing stream files...")
+// archiveDir returns the past-streams folder for a broadcast, named after the day it started
+func archiveDir(dtag string, started time.Time) string {
+	return fmt.Sprintf("%s/%s-%s", pastStreamsDir, started.Format("1-2-2006"), dtag)
+}
 
-	// Create a timestamped folder for this stream's archive
-	archiveFolder := fmt.Sprintf("web/.videos/past-streams/%s-%s",
-		time.Now().Format("1-2-2006"), metadataConfig.Dtag)
+// archiveStream moves a recording's files into its past-streams folder. Files that
+// can't be moved are left in place and reported so the archive can be retried.
+func archiveStream(sourceDir, archiveFolder string) error {
+	log.Printf("archiveStream: Archiving %s to %s", sourceDir, archiveFolder)
 
-	log.Printf("Creating archive directory: %s", archiveFolder)
 	if err := os.MkdirAll(archiveFolder, os.ModePerm); err != nil {
-		log.Fatalf("Failed to create archive folder: %v", err)
-		return
+		return fmt.Errorf("create archive folder: %w", err)
 	}
 
-	// Find all files in the live directory
-	files, err := filepath.Glob("web/live/*")
+	files, err := filepath.Glob(filepath.Join(sourceDir, "*"))
 	if err != nil {
-		log.Fatalf("Failed to list files in live directory: %v", err)
-		return
+		return fmt.Errorf("list %s: %w", sourceDir, err)
 	}
 
 	log.Printf("Found %d files to archive", len(files))
 
-	// Move each file to the archive location
+	var failed []error
 	for _, file := range files {
 		destPath := filepath.Join(archiveFolder, filepath.Base(file))
-		log.Printf("Moving file from %s to %s", file, destPath)
-
-		err := os.Rename(file, destPath)
-		if err != nil {
-			log.Printf("Failed to move file %s: %v", file, err)
 
-			// Try to copy if move fails
-			srcFile, err := os.Open(file)
-			if err != nil {
-				log.Printf("Failed to open source file %s: %v", file, err)
+		if err := os.Rename(file, destPath); err != nil {
+			// Fall back to a copy, e.g. when the archive is on another filesystem
+			if err := copyPath(file, destPath); err != nil {
+				failed = append(failed, fmt.Errorf("%s: %w", filepath.Base(file), err))
 				continue
 			}
-			defer srcFile.Close()
+			os.RemoveAll(file)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d files not archived: %w", len(failed), len(files), errors.Join(failed...))
+	}
 
-			destFile, err := os.Create(destPath)
-			if err != nil {
-				log.Printf("Failed to create destination file %s: %v", destPath, err)
-				continue
-			}
-			defer destFile.Close()
+	log.Println("Archiving completed successfully.")
 
-			_, err = io.Copy(destFile, srcFile)
-			if err != nil {
-				log.Printf("Failed to copy file data: %v", err)
-				continue
-			}
+	catalogArchive(archiveFolder)
+	return nil
+}
+
+// copyPath copies a file, or a rendition directory and everything in it
+func copyPath(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-			// Try to remove original after successful copy
-			os.Remove(file)
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
 		}
-	}
+		target := filepath.Join(dest, rel)
 
-	log.Println("Archiving completed successfully.")
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		srcFile, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer srcFile.Close()
+
+		destFile, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer destFile.Close()
+
+		_, err = io.Copy(destFile, srcFile)
+		return err
+	})
 }
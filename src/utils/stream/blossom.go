@@ -0,0 +1,210 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"goFrame/src/utils/stream/nostr"
+)
+
+// BlossomCopy is a recording stored on a Blossom server
+type BlossomCopy struct {
+	Server string `json:"server"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// blossomRetries holds recordings that some Blossom server failed to take, the janitor
+// retries them like a failed archive
+var blossomRetries = struct {
+	sync.Mutex
+	folders map[string]bool
+}{folders: make(map[string]bool)}
+
+// publishToBlossom uploads a recording to the configured Blossom servers that don't have it
+// yet and re-publishes the end event pointing at them. The MP4 is used when there is one,
+// otherwise the segments of the best rendition are uploaded with a playlist that references
+// them by hash. Recordings a server failed to take are queued for retry.
+func publishToBlossom(folder string) {
+	servers := streamConfig.Archive.BlossomServers
+	if len(servers) == 0 {
+		return
+	}
+
+	manifest, err := readManifest(folder)
+	if err != nil {
+		dequeueBlossomRetry(folder)
+		return
+	}
+
+	var added []BlossomCopy
+	failed := false
+	for _, server := range servers {
+		if hasBlossomCopy(manifest.Blossom, server) {
+			continue
+		}
+
+		var stored BlossomCopy
+		var err error
+		if manifest.MP4 != "" {
+			stored, err = uploadFileToBlossom(server, filepath.Join(folder, manifest.MP4), "video/mp4")
+		} else {
+			stored, err = uploadSegmentsToBlossom(server, folder)
+		}
+		if err != nil {
+			log.Printf("Failed to upload %s to Blossom: %v", folder, err)
+			failed = true
+			continue
+		}
+		log.Printf("Uploaded %s to %s", folder, stored.URL)
+		added = append(added, stored)
+	}
+
+	if failed {
+		blossomRetries.Lock()
+		blossomRetries.folders[folder] = true
+		blossomRetries.Unlock()
+	} else {
+		dequeueBlossomRetry(folder)
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	// Another job may have mirrored the same recording meanwhile, keep every copy once
+	var copies []BlossomCopy
+	err = updateManifest(folder, func(m *PastStream) {
+		for _, stored := range added {
+			if !hasBlossomCopy(m.Blossom, stored.Server) {
+				m.Blossom = append(m.Blossom, stored)
+			}
+		}
+		copies = m.Blossom
+	})
+	if err != nil {
+		reportStorageIssue("manifest", folder, err)
+		return
+	}
+
+	meta, err := readArchivedMetadata(folder)
+	if err != nil || meta.Dtag == "" {
+		return
+	}
+
+	// Content-addressed copies come first so the recording outlives this server
+	event := meta.liveEvent()
+	event.RecordingURL = copies[0].URL
+	for _, stored := range copies[1:] {
+		event.RecordingMirrors = append(event.RecordingMirrors, stored.URL)
+	}
+	if meta.RecordingURL != "" {
+		event.RecordingMirrors = append(event.RecordingMirrors, meta.RecordingURL)
+	}
+	nostr.BroadcastNostrEndEvent(event)
+}
+
+// retryBlossomMirrors uploads queued recordings to the servers that failed them before
+func retryBlossomMirrors() {
+	blossomRetries.Lock()
+	var folders []string
+	for folder := range blossomRetries.folders {
+		folders = append(folders, folder)
+	}
+	blossomRetries.Unlock()
+
+	for _, folder := range folders {
+		log.Printf("Retrying Blossom upload of %s...", folder)
+		publishToBlossom(folder)
+	}
+}
+
+func dequeueBlossomRetry(folder string) {
+	blossomRetries.Lock()
+	delete(blossomRetries.folders, folder)
+	blossomRetries.Unlock()
+}
+
+func hasBlossomCopy(copies []BlossomCopy, server string) bool {
+	for _, stored := range copies {
+		if stored.Server == server {
+			return true
+		}
+	}
+	return false
+}
+
+func uploadFileToBlossom(server, path, mimeType string) (BlossomCopy, error) {
+	sum, size, err := hashFile(path)
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+	defer file.Close()
+
+	blob, err := nostr.BlossomUpload(server, file, size, sum, mimeType, filepath.Base(path))
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+	return BlossomCopy{Server: server, URL: blob.URL, SHA256: blob.SHA256}, nil
+}
+
+// uploadSegmentsToBlossom uploads every segment, then a media playlist listing their Blossom URLs
+func uploadSegmentsToBlossom(server, folder string) (BlossomCopy, error) {
+	playlist, _, err := recordingPlaylist(folder)
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+
+	var out strings.Builder
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		segment := filepath.Join(filepath.Dir(playlist), filepath.FromSlash(line))
+		stored, err := uploadFileToBlossom(server, segment, "video/mp2t")
+		if err != nil {
+			return BlossomCopy{}, fmt.Errorf("segment %s: %w", line, err)
+		}
+		out.WriteString(stored.URL + "\n")
+	}
+
+	// The rewritten playlist is only needed for the upload
+	tmp, err := os.CreateTemp("", "blossom-*.m3u8")
+	if err != nil {
+		return BlossomCopy{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := tmp.WriteString(out.String()); err != nil {
+		return BlossomCopy{}, err
+	}
+
+	return uploadFileToBlossom(server, tmp.Name(), "application/vnd.apple.mpegurl")
+}
+
+// readArchivedMetadata reads the metadata.json saved with a recording
+func readArchivedMetadata(folder string) (MetadataConfig, error) {
+	var meta MetadataConfig
+	data, err := os.ReadFile(filepath.Join(folder, "metadata.json"))
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err
+}
@@ -0,0 +1,369 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"goFrame/src/utils/stream/nostr"
+)
+
+const (
+	chatDir            = "data/chat"
+	chatModerationFile = "data/chat/moderation.json"
+	chatHistorySize    = 500 // Messages kept in memory and replayed to new viewers
+	chatMaxLength      = 2000
+)
+
+// ChatMessage is a NIP-53 kind 1311 live chat message about our stream
+type ChatMessage struct {
+	ID        string `json:"id"`
+	Pubkey    string `json:"pubkey"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at"`
+	Dtag      string `json:"dtag"`
+}
+
+// ChatUpdate is pushed to chat subscribers
+type ChatUpdate struct {
+	Type    string       `json:"type"` // "message", "hide", "unhide", "mute" or "unmute"
+	Message *ChatMessage `json:"message,omitempty"`
+	ID      string       `json:"id,omitempty"`
+	Pubkey  string       `json:"pubkey,omitempty"`
+}
+
+// ChatModeration is the moderator state persisted between restarts
+type ChatModeration struct {
+	MutedPubkeys   []string `json:"muted_pubkeys"`
+	HiddenMessages []string `json:"hidden_messages"`
+}
+
+type chatRoom struct {
+	mu           sync.Mutex
+	dtag         string
+	messages     []ChatMessage
+	seen         map[string]bool
+	muted        map[string]bool
+	hidden       map[string]bool
+	loaded       bool
+	subscription *nostr.Subscription
+	subscribers  map[chan ChatUpdate]struct{}
+}
+
+var chat = &chatRoom{
+	seen:        make(map[string]bool),
+	muted:       make(map[string]bool),
+	hidden:      make(map[string]bool),
+	subscribers: make(map[chan ChatUpdate]struct{}),
+}
+
+// chatOnStateChange follows the stream's chat while it is on air
+func chatOnStateChange(t StateTransition) {
+	switch {
+	case t.To == StateStarting && !t.Resumed:
+		chat.start(t.Dtag)
+	case t.To == StateOffline:
+		chat.stop()
+	}
+}
+
+// ChatHistory returns the visible messages of the current or most recent stream
+func ChatHistory() []ChatMessage {
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+
+	chat.loadModerationLocked()
+	visible := make([]ChatMessage, 0, len(chat.messages))
+	for _, m := range chat.messages {
+		if !chat.muted[m.Pubkey] && !chat.hidden[m.ID] {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// SubscribeChat returns a channel of chat updates and a function to unsubscribe
+func SubscribeChat() (<-chan ChatUpdate, func()) {
+	ch := make(chan ChatUpdate, 32)
+
+	chat.mu.Lock()
+	chat.subscribers[ch] = struct{}{}
+	chat.mu.Unlock()
+
+	return ch, func() {
+		chat.mu.Lock()
+		delete(chat.subscribers, ch)
+		chat.mu.Unlock()
+	}
+}
+
+// GetChatModeration returns the muted pubkeys and hidden messages
+func GetChatModeration() ChatModeration {
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+
+	chat.loadModerationLocked()
+	return chat.moderationLocked()
+}
+
+// MuteChatPubkey hides every message from a pubkey, or shows them again
+func MuteChatPubkey(pubkey string, muted bool) error {
+	if !isHex32(pubkey) {
+		return fmt.Errorf("pubkey must be 64 hex characters")
+	}
+
+	update := ChatUpdate{Type: "unmute", Pubkey: pubkey}
+	if muted {
+		update.Type = "mute"
+	}
+	return chat.moderate(update, func() {
+		if muted {
+			chat.muted[pubkey] = true
+		} else {
+			delete(chat.muted, pubkey)
+		}
+	})
+}
+
+// HideChatMessage hides a single message, or shows it again
+func HideChatMessage(id string, hidden bool) error {
+	if !isHex32(id) {
+		return fmt.Errorf("message id must be 64 hex characters")
+	}
+
+	update := ChatUpdate{Type: "unhide", ID: id}
+	if hidden {
+		update.Type = "hide"
+	}
+	return chat.moderate(update, func() {
+		if hidden {
+			chat.hidden[id] = true
+		} else {
+			delete(chat.hidden, id)
+		}
+	})
+}
+
+func (c *chatRoom) moderate(update ChatUpdate, apply func()) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loadModerationLocked()
+	apply()
+	if err := c.saveModerationLocked(); err != nil {
+		return err
+	}
+
+	// Unhidden messages are sent again so overlays can put them back
+	if update.Type == "unhide" || update.Type == "unmute" {
+		for i := range c.messages {
+			m := c.messages[i]
+			if (m.ID == update.ID || m.Pubkey == update.Pubkey) && !c.muted[m.Pubkey] && !c.hidden[m.ID] {
+				c.broadcastLocked(ChatUpdate{Type: "message", Message: &m})
+			}
+		}
+	}
+	c.broadcastLocked(update)
+	return nil
+}
+
+// start subscribes to chat for a new stream, picking up messages stored before a restart
+func (c *chatRoom) start(dtag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscription != nil {
+		c.subscription.Close()
+	}
+
+	c.loadModerationLocked()
+	c.dtag = dtag
+	c.messages = nil
+	c.seen = make(map[string]bool)
+
+	var since int64
+	for _, m := range readChatLog(dtag) {
+		c.appendLocked(m)
+		since = m.CreatedAt
+	}
+
+	coordinate := nostr.LiveEventCoordinate(dtag)
+	filter := nostr.Filter{Kinds: []int{1311}, ATags: []string{coordinate}, Since: since, Limit: chatHistorySize}
+	c.subscription = nostr.Subscribe(filter, func(event nostr.Event) {
+		c.receive(dtag, coordinate, event)
+	})
+	log.Printf("Listening for live chat on %s", coordinate)
+}
+
+// stop ends the relay subscription, the last stream's chat stays readable
+func (c *chatRoom) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.subscription != nil {
+		c.subscription.Close()
+		c.subscription = nil
+	}
+}
+
+func (c *chatRoom) receive(dtag, coordinate string, event nostr.Event) {
+	if event.Kind != 1311 || !hasTag(event.Tags, "a", coordinate) {
+		return
+	}
+
+	content := event.Content
+	if len(content) > chatMaxLength {
+		content = strings.ToValidUTF8(content[:chatMaxLength], "")
+	}
+	m := ChatMessage{
+		ID:        event.ID,
+		Pubkey:    event.PubKey,
+		Content:   content,
+		CreatedAt: event.CreatedAt,
+		Dtag:      dtag,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Several relays deliver the same message, and a late event may belong to a stream that ended
+	if c.dtag != dtag || c.seen[m.ID] {
+		return
+	}
+	c.appendLocked(m)
+
+	if err := appendChatLog(m); err != nil {
+		log.Printf("Failed to store chat message: %v", err)
+	}
+	if !c.muted[m.Pubkey] && !c.hidden[m.ID] {
+		c.broadcastLocked(ChatUpdate{Type: "message", Message: &m})
+	}
+}
+
+func (c *chatRoom) appendLocked(m ChatMessage) {
+	c.seen[m.ID] = true
+	c.messages = append(c.messages, m)
+
+	// Relays return history newest first
+	sort.SliceStable(c.messages, func(i, j int) bool {
+		return c.messages[i].CreatedAt < c.messages[j].CreatedAt
+	})
+	if len(c.messages) > chatHistorySize {
+		c.messages = c.messages[len(c.messages)-chatHistorySize:]
+	}
+}
+
+func (c *chatRoom) broadcastLocked(update ChatUpdate) {
+	for ch := range c.subscribers {
+		select {
+		case ch <- update:
+		default:
+		}
+	}
+}
+
+func (c *chatRoom) loadModerationLocked() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+
+	data, err := os.ReadFile(chatModerationFile)
+	if err != nil {
+		return
+	}
+	var moderation ChatModeration
+	if err := json.Unmarshal(data, &moderation); err != nil {
+		log.Printf("Failed to parse %s: %v", chatModerationFile, err)
+		return
+	}
+	for _, pk := range moderation.MutedPubkeys {
+		c.muted[pk] = true
+	}
+	for _, id := range moderation.HiddenMessages {
+		c.hidden[id] = true
+	}
+}
+
+func (c *chatRoom) moderationLocked() ChatModeration {
+	moderation := ChatModeration{MutedPubkeys: []string{}, HiddenMessages: []string{}}
+	for pk := range c.muted {
+		moderation.MutedPubkeys = append(moderation.MutedPubkeys, pk)
+	}
+	for id := range c.hidden {
+		moderation.HiddenMessages = append(moderation.HiddenMessages, id)
+	}
+	sort.Strings(moderation.MutedPubkeys)
+	sort.Strings(moderation.HiddenMessages)
+	return moderation
+}
+
+func (c *chatRoom) saveModerationLocked() error {
+	if err := os.MkdirAll(chatDir, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c.moderationLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(chatModerationFile, data, 0644)
+}
+
+// readChatLog loads the stored messages of a stream
+func readChatLog(dtag string) []ChatMessage {
+	file, err := os.Open(filepath.Join(chatDir, dtag+".jsonl"))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var messages []ChatMessage
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var m ChatMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err == nil {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
+
+// appendChatLog stores a message in the stream's chat log
+func appendChatLog(m ChatMessage) error {
+	if err := os.MkdirAll(chatDir, os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(chatDir, m.Dtag+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+func hasTag(tags [][]string, name, value string) bool {
+	for _, tag := range tags {
+		if len(tag) >= 2 && tag[0] == name && tag[1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isHex32(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
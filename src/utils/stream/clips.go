@@ -0,0 +1,266 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+)
+
+const (
+	clipsDir      = "web/.videos/clips"
+	clipName      = "clip.mp4"
+	clipThumbName = "thumb.jpg"
+	maxClipLength = 300 // Seconds
+	clipTimeout   = 10 * time.Minute
+)
+
+// ErrInvalidClip is returned when the requested range can't be cut
+var ErrInvalidClip = errors.New("invalid clip")
+
+// Clip is a short highlight cut from a live or archived stream
+type Clip struct {
+	ID        string  `json:"id"`
+	Dtag      string  `json:"dtag"` // The stream the clip was cut from
+	Title     string  `json:"title"`
+	Start     float64 `json:"start"` // Offset into the stream in seconds
+	End       float64 `json:"end"`
+	Duration  float64 `json:"duration"`
+	Size      int64   `json:"size"`
+	SHA256    string  `json:"sha256"`
+	MIME      string  `json:"mime"`
+	URL       string  `json:"url"`
+	Thumb     string  `json:"thumb"`
+	EventID   string  `json:"event_id"` // Kind 1063 event announcing the clip
+	CreatedAt int64   `json:"created_at"`
+}
+
+// CreateClip cuts the given range out of a stream, stores it and publishes it as a NIP-94 file
+func CreateClip(dtag string, start, end float64, title string) (Clip, error) {
+	if !validDtag.MatchString(dtag) {
+		return Clip{}, os.ErrNotExist
+	}
+	if start < 0 || end <= start {
+		return Clip{}, fmt.Errorf("%w: end must be after start", ErrInvalidClip)
+	}
+	if end-start > maxClipLength {
+		return Clip{}, fmt.Errorf("%w: clips can be at most %d seconds", ErrInvalidClip, maxClipLength)
+	}
+
+	source, length, streamTitle, err := clipSource(dtag)
+	if err != nil {
+		return Clip{}, err
+	}
+	if start >= length {
+		return Clip{}, fmt.Errorf("%w: start is past the end of the stream (%.0f seconds)", ErrInvalidClip, length)
+	}
+	end = min(end, length)
+	if title == "" {
+		title = streamTitle
+	}
+
+	clip := Clip{
+		Dtag:      dtag,
+		Title:     title,
+		Start:     start,
+		End:       end,
+		Duration:  end - start,
+		MIME:      "video/mp4",
+		CreatedAt: time.Now().Unix(),
+	}
+
+	var folder string
+	for {
+		clip.ID = generateDtag()
+		folder = filepath.Join(clipsDir, dtag+"-"+clip.ID)
+		if _, err := os.Stat(folder); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return Clip{}, err
+	}
+
+	if err := cutClip(source, start, end-start, filepath.Join(folder, clipName)); err != nil {
+		os.RemoveAll(folder)
+		return Clip{}, err
+	}
+	clip.SHA256, clip.Size, err = hashFile(filepath.Join(folder, clipName))
+	if err != nil {
+		os.RemoveAll(folder)
+		return Clip{}, err
+	}
+	clip.URL = clipName
+
+	if err := grabFrame(filepath.Join(folder, clipName), filepath.Join(folder, clipThumbName)); err != nil {
+		log.Printf("Failed to generate clip thumbnail: %v", err)
+	} else {
+		clip.Thumb = clipThumbName
+	}
+
+	published := clipURLs(clip)
+	clip.EventID, err = nostr.BroadcastFileMetadata(nostr.FileMetadata{
+		URL:       published.URL,
+		MIME:      clip.MIME,
+		SHA256:    clip.SHA256,
+		Size:      clip.Size,
+		Thumb:     published.Thumb,
+		Image:     published.Thumb,
+		Summary:   clip.Title,
+		Alt:       "Clip from " + streamTitle,
+		Content:   clip.Title,
+		LiveEvent: nostr.LiveEventCoordinate(dtag),
+	})
+	if err != nil {
+		log.Printf("Failed to publish clip %s: %v", clip.ID, err)
+	}
+
+	if err := writeClipManifest(folder, clip); err != nil {
+		return Clip{}, err
+	}
+	return clipURLs(clip), nil
+}
+
+// ListClips returns the clips of a stream, or every clip when dtag is empty, newest first
+func ListClips(dtag string) ([]Clip, error) {
+	pattern := "*"
+	if dtag != "" {
+		if !validDtag.MatchString(dtag) {
+			return []Clip{}, nil
+		}
+		pattern = dtag + "-*"
+	}
+
+	folders, err := filepath.Glob(filepath.Join(clipsDir, pattern, manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	clips := []Clip{}
+	for _, file := range folders {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var clip Clip
+		if err := json.Unmarshal(data, &clip); err != nil {
+			log.Printf("Skipping invalid clip %s: %v", file, err)
+			continue
+		}
+		clips = append(clips, clipURLs(clip))
+	}
+
+	sort.Slice(clips, func(i, j int) bool {
+		return clips[i].CreatedAt > clips[j].CreatedAt
+	})
+	return clips, nil
+}
+
+// clipSource finds the media to cut from: the full playlist of the stream that is on air,
+// or the archived recording, falling back to its MP4 once the segments are gone
+func clipSource(dtag string) (string, float64, string, error) {
+	snapshot := lifecycle.snapshot()
+	if snapshot.Dtag == dtag && snapshot.State != StateOffline && snapshot.State != StateArchived {
+		playlist, entries, err := recordingPlaylist("web/live")
+		if err != nil {
+			return "", 0, "", fmt.Errorf("%w: the stream has no segments yet", ErrInvalidClip)
+		}
+		// The live playlist may be a rolling window, FFmpeg's own playlist has everything
+		if vod := filepath.Join(filepath.Dir(playlist), vodPlaylistName); vod != playlist {
+			if data, err := os.ReadFile(vod); err == nil {
+				playlist, entries = vod, parseMediaPlaylist(data)
+			}
+		}
+
+		metadataMutex.Lock()
+		title := metadataConfig.Title
+		metadataMutex.Unlock()
+		return playlist, playlistDuration(entries), title, nil
+	}
+
+	for _, folder := range archiveFolders() {
+		manifest, err := readManifest(folder)
+		if err != nil || manifest.Dtag != dtag {
+			continue
+		}
+		if manifest.SegmentsDeleted {
+			return filepath.Join(folder, manifest.MP4), manifest.Duration, manifest.Title, nil
+		}
+		playlist, entries, err := recordingPlaylist(folder)
+		if err != nil {
+			return "", 0, "", err
+		}
+		return playlist, playlistDuration(entries), manifest.Title, nil
+	}
+	return "", 0, "", os.ErrNotExist
+}
+
+// cutClip re-encodes the range so the clip starts exactly on the requested frame
+func cutClip(source string, start, duration float64, output string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clipTimeout)
+	defer cancel()
+
+	args := []string{"-y", "-loglevel", "error"}
+	if strings.HasSuffix(source, ".m3u8") {
+		// Read a playlist that is still growing from the beginning, not the live edge
+		args = append(args, "-live_start_index", "0")
+	}
+	tmp := output + ".tmp"
+	args = append(args,
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-i", source,
+		"-t", strconv.FormatFloat(duration, 'f', 3, 64),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		tmp,
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Rename(tmp, output)
+}
+
+func writeClipManifest(folder string, clip Clip) error {
+	data, err := json.MarshalIndent(clip, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(folder, manifestName), data, 0644)
+}
+
+// clipURLs turns the clip's file names into links clients can load
+func clipURLs(clip Clip) Clip {
+	base := "/" + path.Join(strings.TrimPrefix(clipsDir, "web/"), clip.Dtag+"-"+clip.ID)
+	if clip.URL != "" {
+		clip.URL = utils.MediaURL(base + "/" + clip.URL)
+	}
+	if clip.Thumb != "" {
+		clip.Thumb = utils.MediaURL(base + "/" + clip.Thumb)
+	}
+	return clip
+}
+
+func playlistDuration(entries []playlistEntry) float64 {
+	var total float64
+	for _, e := range entries {
+		total += e.duration
+	}
+	return total
+}
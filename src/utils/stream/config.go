@@ -3,14 +3,61 @@ package stream
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"sync"
 
 	"gopkg.in/yaml.v3"
+
+	"goFrame/src/utils/stream/nostr"
 )
 
 type StreamConfig struct {
-	RTMPStreamURL string `yaml:"rtmp_stream_url"`
+	RTMP      RTMPConfig      `yaml:"rtmp"`
+	HLS       HLSConfig       `yaml:"hls"`
+	Lifecycle LifecycleConfig `yaml:"lifecycle"`
+	Viewers   ViewersConfig   `yaml:"viewers"`
+	Archive   ArchiveConfig   `yaml:"archive"`
+}
+
+// RTMPConfig holds settings for the embedded RTMP ingest server
+type RTMPConfig struct {
+	Listen    string `yaml:"listen"`     // Listen address, defaults to ":1935"
+	App       string `yaml:"app"`        // Application name in the ingest URL, defaults to "live"
+	StreamKey string `yaml:"stream_key"` // Key publishers must present
+}
+
+// HLSConfig holds settings for the HLS packager
+type HLSConfig struct {
+	SegmentTime int         `yaml:"segment_time"` // Target segment length in seconds, defaults to 10
+	Renditions  []Rendition `yaml:"renditions"`   // Adaptive bitrate ladder, defaults to 1080p/720p/480p/audio
+	LiveWindow  int         `yaml:"live_window"`  // Segments in the rolling live playlist, 0 keeps the full playlist live
+	LowLatency  bool        `yaml:"low_latency"`  // Publish LL-HLS partial segments, implies a rolling window
+	PartTime    float64     `yaml:"part_time"`    // Partial segment length in seconds, defaults to 1
+}
+
+// LifecycleConfig holds settings for stream state transitions
+type LifecycleConfig struct {
+	ReconnectGrace int      `yaml:"reconnect_grace"` // Seconds a dropped publisher may reconnect into the same event, defaults to 30
+	Webhooks       []string `yaml:"webhooks"`        // URLs that receive every state transition as a JSON POST
+	WebhookSecret  string   `yaml:"webhook_secret"`  // Optional HMAC-SHA256 key for the X-Stream-Signature header
+	ScheduleWindow int      `yaml:"schedule_window"` // Seconds around a scheduled start in which going live claims that event, defaults to 7200
+}
+
+// ViewersConfig holds settings for viewer counting
+type ViewersConfig struct {
+	Window          int `yaml:"window"`           // Seconds since the last playlist fetch a viewer still counts, defaults to 30
+	PublishInterval int `yaml:"publish_interval"` // Minimum seconds between participant count updates on Nostr, defaults to 60
+}
+
+// ArchiveConfig holds settings for post-processing past streams
+type ArchiveConfig struct {
+	RemuxMP4         bool `yaml:"remux_mp4"`         // Remux each recording into a downloadable MP4, defaults to true
+	SegmentRetention int  `yaml:"segment_retention"` // Days to keep HLS segments once the MP4 exists, 0 keeps them
+
+	// Retention, pinned recordings are never deleted
+	KeepDays int     `yaml:"keep_days"` // Delete recordings older than this, 0 keeps them forever
+	KeepGB   float64 `yaml:"keep_gb"`   // Delete the oldest recordings while the archive is larger, 0 is unlimited
+
+	BlossomServers []string `yaml:"blossom_servers"` // Blossom servers that receive a copy of every recording
 }
 
 type MetadataConfig struct {
@@ -25,10 +72,38 @@ type MetadataConfig struct {
 	Starts       string   `yaml:"starts" json:"starts"`
 	Ends         string   `yaml:"ends" json:"ends"`
 	Status       string   `yaml:"status" json:"status"`
+
+	// Filled in from viewer tracking, never read from stream.yml
+	CurrentParticipants int `yaml:"-" json:"current_participants"`
+	TotalParticipants   int `yaml:"-" json:"total_participants"`
+}
+
+// liveEvent converts the metadata into the Nostr live event it describes
+func (m MetadataConfig) liveEvent() nostr.LiveEvent {
+	return nostr.LiveEvent{
+		Dtag:                m.Dtag,
+		Title:               m.Title,
+		Summary:             m.Summary,
+		Image:               m.Image,
+		Tags:                m.Tags,
+		StreamURL:           m.StreamURL,
+		RecordingURL:        m.RecordingURL,
+		Starts:              m.Starts,
+		Ends:                m.Ends,
+		Status:              m.Status,
+		CurrentParticipants: m.CurrentParticipants,
+		TotalParticipants:   m.TotalParticipants,
+	}
+}
+
+// currentLiveEvent returns the live event for the current metadata
+func currentLiveEvent() nostr.LiveEvent {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	return metadataConfig.liveEvent()
 }
 
 var (
-	ffmpegCmd      *exec.Cmd
 	streamConfig   StreamConfig
 	metadataConfig MetadataConfig
 	metadataMutex  sync.Mutex
@@ -39,7 +114,38 @@ func LoadStreamConfig(path string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
-	return yaml.Unmarshal(data, &streamConfig)
+
+	// Defaults that a zero value in the file may override
+	streamConfig.Lifecycle.ReconnectGrace = 30
+	streamConfig.Lifecycle.ScheduleWindow = 7200
+	streamConfig.Archive.RemuxMP4 = true
+
+	if err := yaml.Unmarshal(data, &streamConfig); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	if streamConfig.RTMP.Listen == "" {
+		streamConfig.RTMP.Listen = ":1935"
+	}
+	if streamConfig.RTMP.App == "" {
+		streamConfig.RTMP.App = "live"
+	}
+	if streamConfig.HLS.SegmentTime <= 0 {
+		streamConfig.HLS.SegmentTime = 10
+	}
+	if streamConfig.HLS.PartTime <= 0 {
+		streamConfig.HLS.PartTime = defaultPartTime
+	}
+	if streamConfig.Viewers.Window <= 0 {
+		streamConfig.Viewers.Window = 30
+	}
+	if streamConfig.Viewers.PublishInterval <= 0 {
+		streamConfig.Viewers.PublishInterval = 60
+	}
+	if streamConfig.RTMP.StreamKey == "" {
+		return fmt.Errorf("rtmp.stream_key must be set")
+	}
+	return nil
 }
 
 func LoadMetadataConfig(path string) error {
@@ -0,0 +1,229 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rendition is one variant of the adaptive bitrate ladder
+type Rendition struct {
+	Name         string `yaml:"name"`          // Directory and variant name, e.g. "720p"
+	Width        int    `yaml:"width"`         // Optional, derived from height at 16:9 when zero
+	Height       int    `yaml:"height"`        // Output height, ignored for audio-only variants
+	VideoBitrate string `yaml:"video_bitrate"` // e.g. "3000k"
+	AudioBitrate string `yaml:"audio_bitrate"` // e.g. "128k"
+	AudioOnly    bool   `yaml:"audio_only"`
+}
+
+// defaultRenditions is used when config.yml does not define a ladder
+var defaultRenditions = []Rendition{
+	{Name: "1080p", Height: 1080, VideoBitrate: "6000k", AudioBitrate: "160k"},
+	{Name: "720p", Height: 720, VideoBitrate: "3000k", AudioBitrate: "128k"},
+	{Name: "480p", Height: 480, VideoBitrate: "1200k", AudioBitrate: "96k"},
+	{Name: "audio", AudioBitrate: "96k", AudioOnly: true},
+}
+
+const (
+	masterPlaylistName  = "index.m3u8"
+	variantPlaylistName = "output.m3u8"
+	videoCodecs         = "avc1.640028,mp4a.40.2"
+	videoOnlyCodecs     = "avc1.640028"
+	audioCodecs         = "mp4a.40.2"
+)
+
+// sourceAudio records whether the current publisher sends audio, the ladder leaves
+// audio out for video-only sources
+var sourceAudio = struct {
+	sync.Mutex
+	present bool
+}{present: true}
+
+func setSourceAudio(present bool) {
+	sourceAudio.Lock()
+	sourceAudio.present = present
+	sourceAudio.Unlock()
+}
+
+func sourceHasAudio() bool {
+	sourceAudio.Lock()
+	defer sourceAudio.Unlock()
+	return sourceAudio.present
+}
+
+// renditions returns the configured ladder or the default one, without audio-only
+// variants when the source has no audio
+func renditions() []Rendition {
+	ladder := defaultRenditions
+	if len(streamConfig.HLS.Renditions) > 0 {
+		ladder = streamConfig.HLS.Renditions
+	}
+	if sourceHasAudio() {
+		return ladder
+	}
+
+	var withVideo []Rendition
+	for _, r := range ladder {
+		if !r.AudioOnly {
+			withVideo = append(withVideo, r)
+		}
+	}
+	return withVideo
+}
+
+// buildHLSArgs builds the FFmpeg arguments that encode every rendition from stdin
+func buildHLSArgs(outputDir string) []string {
+	ladder := renditions()
+	withAudio := sourceHasAudio()
+
+	// With a rolling window FFmpeg writes the full VOD playlist and the live playlist is built from it.
+	// Low latency cuts FFmpeg segments at part length, they are joined into full segments later.
+	hlsTime := strconv.Itoa(streamConfig.HLS.SegmentTime)
+	playlistName := variantPlaylistName
+	segmentName := "segment%d.ts"
+	if rollingWindowEnabled() {
+		playlistName = vodPlaylistName
+	}
+	if streamConfig.HLS.LowLatency {
+		hlsTime = strconv.FormatFloat(streamConfig.HLS.PartTime, 'f', -1, 64)
+		segmentName = "part%d.ts"
+	}
+
+	args := []string{"-f", "flv", "-i", "pipe:0"}
+
+	// Split the source video once per video rendition and scale each branch
+	var videoLadder []Rendition
+	for _, r := range ladder {
+		if !r.AudioOnly {
+			videoLadder = append(videoLadder, r)
+		}
+	}
+	if len(videoLadder) > 0 {
+		var filter strings.Builder
+		fmt.Fprintf(&filter, "[0:v]split=%d", len(videoLadder))
+		for i := range videoLadder {
+			fmt.Fprintf(&filter, "[v%d]", i)
+		}
+		for i, r := range videoLadder {
+			// Never upscale past the source height
+			fmt.Fprintf(&filter, ";[v%d]scale=-2:'min(%d,ih)'[v%dout]", i, r.Height, i)
+		}
+		args = append(args, "-filter_complex", filter.String())
+	}
+
+	var streamMap []string
+	videoIndex, audioIndex := 0, 0
+	for _, r := range ladder {
+		if r.AudioOnly {
+			args = append(args,
+				"-map", "0:a:0?",
+				fmt.Sprintf("-b:a:%d", audioIndex), r.AudioBitrate,
+			)
+			streamMap = append(streamMap, fmt.Sprintf("a:%d,name:%s", audioIndex, r.Name))
+			audioIndex++
+			continue
+		}
+
+		maxrate := scaleBitrate(r.VideoBitrate, 1.07)
+		bufsize := scaleBitrate(r.VideoBitrate, 1.5)
+		args = append(args,
+			"-map", fmt.Sprintf("[v%dout]", videoIndex),
+			fmt.Sprintf("-b:v:%d", videoIndex), r.VideoBitrate,
+			fmt.Sprintf("-maxrate:v:%d", videoIndex), maxrate,
+			fmt.Sprintf("-bufsize:v:%d", videoIndex), bufsize,
+		)
+		if !withAudio {
+			streamMap = append(streamMap, fmt.Sprintf("v:%d,name:%s", videoIndex, r.Name))
+			videoIndex++
+			continue
+		}
+		args = append(args,
+			"-map", "0:a:0?",
+			fmt.Sprintf("-b:a:%d", audioIndex), r.AudioBitrate,
+		)
+		streamMap = append(streamMap, fmt.Sprintf("v:%d,a:%d,name:%s", videoIndex, audioIndex, r.Name))
+		videoIndex++
+		audioIndex++
+	}
+
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "high",
+		// Keyframes on segment boundaries keep every rendition switchable
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%s)", hlsTime),
+		"-sc_threshold", "0",
+		"-c:a", "aac",
+		"-ac", "2",
+		"-f", "hls",
+		"-hls_time", hlsTime,
+		"-hls_list_size", "0",
+		// Restarts continue the playlist instead of overwriting earlier segments
+		"-hls_flags", "independent_segments+append_list+discont_start",
+		"-hls_segment_filename", filepath.Join(outputDir, "%v", segmentName),
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(outputDir, "%v", playlistName),
+	)
+
+	return args
+}
+
+// writeMasterPlaylist writes an index.m3u8 listing every rendition's playlist
+func writeMasterPlaylist(dir, variantPlaylist string) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	withAudio := sourceHasAudio()
+	for _, r := range renditions() {
+		if err := os.MkdirAll(filepath.Join(dir, r.Name), os.ModePerm); err != nil {
+			return err
+		}
+
+		audioBits, codecs := parseBitrate(r.AudioBitrate), videoCodecs
+		if !withAudio {
+			audioBits, codecs = 0, videoOnlyCodecs
+		}
+		if r.AudioOnly {
+			fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,CODECS=\"%s\"\n", audioBits*11/10, audioCodecs)
+		} else {
+			width := r.Width
+			if width == 0 {
+				// Round the 16:9 width to an even number like scale=-2 does
+				width = (r.Height*16/9 + 1) &^ 1
+			}
+			bandwidth := (parseBitrate(r.VideoBitrate) + audioBits) * 11 / 10
+			fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n",
+				bandwidth, width, r.Height, codecs)
+		}
+		fmt.Fprintf(&b, "%s/%s\n", r.Name, variantPlaylist)
+	}
+
+	return os.WriteFile(filepath.Join(dir, masterPlaylistName), []byte(b.String()), 0644)
+}
+
+// parseBitrate converts FFmpeg bitrate strings like "3000k" or "6M" to bits per second
+func parseBitrate(s string) int {
+	s = strings.TrimSpace(strings.ToLower(s))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		multiplier = 1000
+		s = strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "m"):
+		multiplier = 1000000
+		s = strings.TrimSuffix(s, "m")
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return int(value * float64(multiplier))
+}
+
+// scaleBitrate multiplies a bitrate string and returns it in kbit/s
+func scaleBitrate(s string, factor float64) string {
+	return fmt.Sprintf("%dk", int(float64(parseBitrate(s))*factor/1000))
+}
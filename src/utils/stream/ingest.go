@@ -0,0 +1,189 @@
+package stream
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"goFrame/src/utils/stream/rtmp"
+)
+
+// publishEvent is emitted by the RTMP server when a publisher starts or stops
+type publishEvent struct {
+	started bool
+}
+
+// publishEvents feeds the stream loop. It holds only the latest event: the loop runs the
+// lifecycle hooks (broadcasts, archiving, webhooks) synchronously, and the RTMP session
+// must not wait on them, so an event the loop hasn't picked up yet is replaced by a newer one.
+var publishEvents = make(chan publishEvent, 1)
+
+// signalPublish hands the publisher's current state to the stream loop without blocking
+func signalPublish(started bool) {
+	for {
+		select {
+		case publishEvents <- publishEvent{started: started}:
+			return
+		default:
+		}
+		// Drop the stale pending event, the loop only needs to catch up with the latest state
+		select {
+		case <-publishEvents:
+		default:
+		}
+	}
+}
+
+// ingestRelay receives tags from the RTMP server and writes them as FLV to the transcoder input
+type ingestRelay struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+
+	// Decoder configuration replayed whenever a new output is attached mid-stream
+	metadata    []byte
+	videoConfig []byte
+	audioConfig []byte
+
+	// What the publisher sends, so the ladder can leave out audio for video-only sources
+	metadataKnown bool
+	metadataAudio bool
+	audioSeen     bool
+	videoSeen     bool
+}
+
+var ingest = &ingestRelay{}
+
+// PublishStart clears cached headers from the previous publisher and signals the stream loop
+func (r *ingestRelay) PublishStart(app, streamKey string) error {
+	r.mu.Lock()
+	r.metadata = nil
+	r.videoConfig = nil
+	r.audioConfig = nil
+	r.metadataKnown = false
+	r.metadataAudio = false
+	r.audioSeen = false
+	r.videoSeen = false
+	r.mu.Unlock()
+
+	signalPublish(true)
+	return nil
+}
+
+// WriteTag forwards a tag to the attached output, caching any decoder configuration
+func (r *ingestRelay) WriteTag(tagType uint8, timestamp uint32, data []byte) {
+	tag := rtmp.EncodeFLVTag(tagType, timestamp, data)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch tagType {
+	case rtmp.TagAudio:
+		r.audioSeen = true
+	case rtmp.TagVideo:
+		r.videoSeen = true
+	}
+
+	switch {
+	case tagType == rtmp.TagScript:
+		r.metadata = rtmp.EncodeFLVTag(tagType, 0, data)
+		if hasAudio, ok := rtmp.MetadataHasAudio(data); ok {
+			r.metadataKnown = true
+			r.metadataAudio = hasAudio
+		}
+	case rtmp.IsSequenceHeader(tagType, data) && tagType == rtmp.TagVideo:
+		r.videoConfig = rtmp.EncodeFLVTag(tagType, 0, data)
+	case rtmp.IsSequenceHeader(tagType, data) && tagType == rtmp.TagAudio:
+		r.audioConfig = rtmp.EncodeFLVTag(tagType, 0, data)
+	}
+
+	if r.out == nil {
+		return
+	}
+
+	if _, err := r.out.Write(tag); err != nil {
+		log.Printf("ingest: transcoder input closed: %v", err)
+		r.out.Close()
+		r.out = nil
+	}
+}
+
+// PublishStop signals the stream loop that the publisher went away
+func (r *ingestRelay) PublishStop(app, streamKey string) {
+	signalPublish(false)
+}
+
+// hasAudio reports whether the publisher sends audio. It waits up to timeout for the
+// metadata or the first tags, and assumes audio when the publisher sent nothing at all.
+func (r *ingestRelay) hasAudio(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		r.mu.Lock()
+		audio, known := r.audioSeen, r.audioSeen || r.metadataKnown
+		if !audio && r.metadataKnown {
+			audio = r.metadataAudio
+		}
+		videoSeen := r.videoSeen
+		r.mu.Unlock()
+
+		if known {
+			return audio
+		}
+		if time.Now().After(deadline) {
+			// Video without any audio tag by now is a video-only source
+			return !videoSeen
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// attach starts writing FLV to w, beginning with the header and any cached configuration
+func (r *ingestRelay) attach(w io.WriteCloser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.out != nil {
+		r.out.Close()
+	}
+
+	if _, err := w.Write(rtmp.FLVHeader); err != nil {
+		return err
+	}
+	for _, tag := range [][]byte{r.metadata, r.videoConfig, r.audioConfig} {
+		if tag == nil {
+			continue
+		}
+		if _, err := w.Write(tag); err != nil {
+			return err
+		}
+	}
+
+	r.out = w
+	return nil
+}
+
+// detach closes the current output so the transcoder sees end of input
+func (r *ingestRelay) detach() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.out != nil {
+		r.out.Close()
+		r.out = nil
+	}
+}
+
+// startIngestServer runs the embedded RTMP listener for the lifetime of the process.
+// When it can't listen, ingest stays disabled and the rest of the server keeps running.
+func startIngestServer() {
+	server := &rtmp.Server{
+		Addr:      streamConfig.RTMP.Listen,
+		App:       streamConfig.RTMP.App,
+		StreamKey: streamConfig.RTMP.StreamKey,
+		Handler:   ingest,
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("RTMP ingest server failed, live streaming is disabled: %v", err)
+	}
+}
@@ -0,0 +1,515 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vodPlaylistName    = "vod.m3u8"
+	livePollInterval   = 200 * time.Millisecond
+	liveEdgeSegments   = 2 // Complete segments before the live edge that still list their parts
+	defaultLiveWindow  = 6
+	defaultPartTime    = 1.0
+	blockingReloadWait = 3 // Target durations a blocking reload may wait
+)
+
+// livePart is one FFmpeg segment, used as an LL-HLS partial segment. FFmpeg can't write
+// parts of a segment, so each part is a short segment of its own starting on a forced
+// keyframe. Players get the lower latency, at the cost of a keyframe every part_time.
+type livePart struct {
+	uri      string
+	duration float64
+}
+
+// liveSegment is one entry of the rolling live playlist
+type liveSegment struct {
+	seq           int
+	parts         []livePart
+	duration      float64
+	uri           string
+	discontinuity bool
+	complete      bool
+}
+
+// liveWindow turns FFmpeg's full VOD playlist into a rolling live playlist for one rendition
+type liveWindow struct {
+	dir             string
+	windowSize      int
+	lowLatency      bool
+	partTarget      float64
+	partsPerSegment int
+
+	mu           sync.Mutex
+	segments     []*liveSegment
+	nextSeq      int
+	discSeq      int // Discontinuities that have scrolled out of the window
+	consumed     int // VOD playlist entries already processed
+	lastModified time.Time
+	playlist     []byte
+	updated      chan struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	liveWindowsMutex sync.Mutex
+	liveWindows      = make(map[string]*liveWindow) // rendition name -> window
+	pausedWindows    = make(map[string]*liveWindow) // Stopped for a reconnect, resumed by the next start
+)
+
+// rollingWindowEnabled reports whether FFmpeg writes a VOD playlist that we window ourselves
+func rollingWindowEnabled() bool {
+	return streamConfig.HLS.LiveWindow > 0 || streamConfig.HLS.LowLatency
+}
+
+// startLiveWindows begins maintaining a live playlist for every rendition
+func startLiveWindows(outputDir string) {
+	liveWindowsMutex.Lock()
+	defer liveWindowsMutex.Unlock()
+
+	windowSize := streamConfig.HLS.LiveWindow
+	if windowSize <= 0 {
+		windowSize = defaultLiveWindow
+	}
+
+	partsPerSegment := 1
+	partTarget := float64(streamConfig.HLS.SegmentTime)
+	if streamConfig.HLS.LowLatency {
+		partTarget = streamConfig.HLS.PartTime
+		partsPerSegment = int(math.Round(float64(streamConfig.HLS.SegmentTime) / partTarget))
+		if partsPerSegment < 1 {
+			partsPerSegment = 1
+		}
+	}
+
+	for _, r := range renditions() {
+		w := &liveWindow{
+			dir:             filepath.Join(outputDir, r.Name),
+			windowSize:      windowSize,
+			lowLatency:      streamConfig.HLS.LowLatency,
+			partTarget:      partTarget,
+			partsPerSegment: partsPerSegment,
+			updated:         make(chan struct{}),
+			stop:            make(chan struct{}),
+			done:            make(chan struct{}),
+		}
+
+		// After a reconnect FFmpeg appends to the same VOD playlist, carry on where the window stopped
+		if paused, ok := pausedWindows[r.Name]; ok && paused.dir == w.dir {
+			w.segments = paused.segments
+			w.nextSeq = paused.nextSeq
+			w.discSeq = paused.discSeq
+			w.consumed = paused.consumed
+			w.partTarget = paused.partTarget
+			w.playlist = paused.playlist
+		}
+		delete(pausedWindows, r.Name)
+
+		liveWindows[r.Name] = w
+		go w.run()
+	}
+}
+
+// stopLiveWindows stops the playlist writers, keeping their position for a reconnect
+func stopLiveWindows() {
+	liveWindowsMutex.Lock()
+	defer liveWindowsMutex.Unlock()
+
+	for name, w := range liveWindows {
+		close(w.stop)
+		<-w.done
+		os.Remove(filepath.Join(w.dir, variantPlaylistName))
+		pausedWindows[name] = w
+		delete(liveWindows, name)
+	}
+}
+
+// resetLiveWindows forgets the windows of the previous stream so a new one starts from scratch
+func resetLiveWindows() {
+	liveWindowsMutex.Lock()
+	defer liveWindowsMutex.Unlock()
+
+	clear(pausedWindows)
+}
+
+// WaitForLivePlaylist blocks until the rendition's live playlist contains the requested
+// media sequence number and part, as required for LL-HLS blocking playlist reloads.
+// A part of -1 waits for the whole segment. It returns false if no window is active.
+func WaitForLivePlaylist(rendition string, msn, part int) ([]byte, bool) {
+	liveWindowsMutex.Lock()
+	w, ok := liveWindows[rendition]
+	liveWindowsMutex.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	w.mu.Lock()
+	timeout := time.Duration(blockingReloadWait*w.targetDurationLocked()) * time.Second
+	w.mu.Unlock()
+	deadline := time.After(timeout)
+
+	for {
+		w.mu.Lock()
+		if w.hasLocked(msn, part) {
+			playlist := w.playlist
+			w.mu.Unlock()
+			return playlist, true
+		}
+		updated := w.updated
+		w.mu.Unlock()
+
+		select {
+		case <-updated:
+		case <-deadline:
+			// Serve what we have rather than hold the player forever
+			w.mu.Lock()
+			playlist := w.playlist
+			w.mu.Unlock()
+			return playlist, true
+		}
+	}
+}
+
+func (w *liveWindow) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(livePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.refresh()
+			return
+		case <-ticker.C:
+			w.refresh()
+		}
+	}
+}
+
+// refresh reads any new entries from the VOD playlist and rewrites the live playlist
+func (w *liveWindow) refresh() {
+	vodPath := filepath.Join(w.dir, vodPlaylistName)
+	info, err := os.Stat(vodPath)
+	if err != nil || !info.ModTime().After(w.lastModified) {
+		return
+	}
+
+	data, err := os.ReadFile(vodPath)
+	if err != nil {
+		log.Printf("Live window: failed to read %s: %v", vodPath, err)
+		return
+	}
+	w.lastModified = info.ModTime()
+
+	entries := parseMediaPlaylist(data)
+	if len(entries) <= w.consumed {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, e := range entries[w.consumed:] {
+		w.addPartLocked(e)
+	}
+	w.consumed = len(entries)
+
+	w.playlist = w.renderLocked()
+	// Rename so players never read a half-written playlist
+	tmp := filepath.Join(w.dir, variantPlaylistName+".tmp")
+	if err := os.WriteFile(tmp, w.playlist, 0644); err != nil {
+		log.Printf("Live window: failed to write playlist: %v", err)
+	} else if err := os.Rename(tmp, filepath.Join(w.dir, variantPlaylistName)); err != nil {
+		log.Printf("Live window: failed to replace playlist: %v", err)
+	}
+
+	// Wake blocked playlist requests
+	close(w.updated)
+	w.updated = make(chan struct{})
+}
+
+func (w *liveWindow) addPartLocked(e playlistEntry) {
+	var current *liveSegment
+	if n := len(w.segments); n > 0 && !w.segments[n-1].complete {
+		current = w.segments[n-1]
+	}
+
+	// A transcoder restart always starts a new segment
+	if e.discontinuity && current != nil {
+		w.completeSegmentLocked(current)
+		current = nil
+	}
+
+	if current == nil {
+		current = &liveSegment{seq: w.nextSeq, discontinuity: e.discontinuity}
+		w.nextSeq++
+		w.segments = append(w.segments, current)
+	}
+
+	current.parts = append(current.parts, livePart{uri: e.uri, duration: e.duration})
+	current.duration += e.duration
+	if e.duration > w.partTarget && w.lowLatency {
+		w.partTarget = math.Ceil(e.duration*1000) / 1000
+	}
+
+	if len(current.parts) >= w.partsPerSegment {
+		w.completeSegmentLocked(current)
+	}
+}
+
+func (w *liveWindow) completeSegmentLocked(seg *liveSegment) {
+	seg.complete = true
+	seg.uri = seg.parts[0].uri
+
+	// Parent segments are the concatenated parts, MPEG-TS concatenates cleanly
+	if w.lowLatency {
+		seg.uri = fmt.Sprintf("segment%d.ts", seg.seq)
+		if err := concatParts(w.dir, seg); err != nil {
+			log.Printf("Live window: failed to build segment %d: %v", seg.seq, err)
+		}
+	}
+
+	// Keep the window plus the in-progress segment
+	for w.completeCountLocked() > w.windowSize {
+		dropped := w.segments[0]
+		w.segments = w.segments[1:]
+		if w.lowLatency {
+			os.Remove(filepath.Join(w.dir, dropped.uri))
+		}
+		if dropped.discontinuity {
+			w.discSeq++
+		}
+	}
+}
+
+func (w *liveWindow) completeCountLocked() int {
+	count := 0
+	for _, seg := range w.segments {
+		if seg.complete {
+			count++
+		}
+	}
+	return count
+}
+
+func (w *liveWindow) targetDurationLocked() int {
+	target := 1
+	for _, seg := range w.segments {
+		if seg.complete && int(math.Ceil(seg.duration)) > target {
+			target = int(math.Ceil(seg.duration))
+		}
+	}
+	if target < streamConfig.HLS.SegmentTime {
+		target = streamConfig.HLS.SegmentTime
+	}
+	return target
+}
+
+// hasLocked reports whether the playlist already contains the requested segment or part
+func (w *liveWindow) hasLocked(msn, part int) bool {
+	for i := len(w.segments) - 1; i >= 0; i-- {
+		seg := w.segments[i]
+		if seg.seq > msn {
+			return true
+		}
+		if seg.seq == msn {
+			if part < 0 {
+				return seg.complete
+			}
+			return seg.complete || len(seg.parts) > part
+		}
+	}
+	return false
+}
+
+func (w *liveWindow) renderLocked() []byte {
+	var b strings.Builder
+
+	version := 3
+	if w.lowLatency {
+		version = 6
+	}
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", w.targetDurationLocked())
+	if w.lowLatency {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*w.partTarget)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", w.partTarget)
+	}
+	if len(w.segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", w.segments[0].seq)
+	}
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", w.discSeq)
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	// Only segments near the live edge list their parts
+	partsFrom := len(w.segments) - liveEdgeSegments - 1
+	for i, seg := range w.segments {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if w.lowLatency && i >= partsFrom {
+			for _, p := range seg.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\",INDEPENDENT=YES\n", p.duration, p.uri)
+			}
+		}
+		if seg.complete {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.duration, seg.uri)
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// concatParts writes a parent segment from its part files
+func concatParts(dir string, seg *liveSegment) error {
+	tmp := filepath.Join(dir, seg.uri+".tmp")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range seg.parts {
+		in, err := os.Open(filepath.Join(dir, p.uri))
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, seg.uri))
+}
+
+// joinRecordingParts rewrites each rendition's low latency recording from part-length
+// segments into full segments, so the archive isn't thousands of tiny files. The parts
+// are only removed once the new VOD playlist is in place.
+func joinRecordingParts(outputDir string) {
+	if !streamConfig.HLS.LowLatency {
+		return
+	}
+	partsPerSegment := max(int(math.Round(float64(streamConfig.HLS.SegmentTime)/streamConfig.HLS.PartTime)), 1)
+
+	for _, r := range renditions() {
+		dir := filepath.Join(outputDir, r.Name)
+		if err := joinParts(dir, partsPerSegment); err != nil {
+			log.Printf("Failed to join the recording parts in %s, archiving them as they are: %v", dir, err)
+		}
+	}
+}
+
+func joinParts(dir string, partsPerSegment int) error {
+	vodPath := filepath.Join(dir, vodPlaylistName)
+	data, err := os.ReadFile(vodPath)
+	if err != nil {
+		return err
+	}
+
+	// Parent segments left by the live window are rebuilt from the whole recording
+	old, _ := filepath.Glob(filepath.Join(dir, "segment*.ts"))
+	for _, m := range old {
+		os.Remove(m)
+	}
+
+	var segments []*liveSegment
+	var current *liveSegment
+	for _, e := range parseMediaPlaylist(data) {
+		if current == nil || len(current.parts) >= partsPerSegment || e.discontinuity {
+			current = &liveSegment{
+				seq:           len(segments),
+				uri:           fmt.Sprintf("segment%d.ts", len(segments)),
+				discontinuity: e.discontinuity,
+			}
+			segments = append(segments, current)
+		}
+		current.parts = append(current.parts, livePart{uri: e.uri, duration: e.duration})
+		current.duration += e.duration
+	}
+
+	target := streamConfig.HLS.SegmentTime
+	for _, seg := range segments {
+		if err := concatParts(dir, seg); err != nil {
+			return err
+		}
+		target = max(target, int(math.Ceil(seg.duration)))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", target)
+	b.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, seg := range segments {
+		if seg.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.duration, seg.uri)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	tmp := vodPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, vodPath); err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		for _, p := range seg.parts {
+			os.Remove(filepath.Join(dir, p.uri))
+		}
+	}
+	return nil
+}
+
+// playlistEntry is one media segment from an HLS media playlist
+type playlistEntry struct {
+	uri           string
+	duration      float64
+	discontinuity bool
+}
+
+// parseMediaPlaylist extracts the segments of a media playlist
+func parseMediaPlaylist(data []byte) []playlistEntry {
+	var entries []playlistEntry
+	var pending playlistEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			value, _, _ = strings.Cut(value, ",")
+			pending.duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			pending.discontinuity = true
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.uri = line
+			entries = append(entries, pending)
+			pending = playlistEntry{}
+		}
+	}
+	return entries
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -12,12 +11,19 @@ import (
 	"goFrame/src/utils/stream/nostr"
 )
 
-// Watch metadata file and update JSON when changes occur
-func watchMetadata(stopWatcher chan bool) {
+// Watch metadata file and update JSON when changes occur.
+// With skipCurrent only edits made after the watcher starts are applied.
+func watchMetadata(stopWatcher chan bool, skipCurrent bool) {
 	lastModified := time.Time{}
 	metadataFile := "web/live/metadata.json"
 	yamlFile := "stream.yml"
 
+	if skipCurrent {
+		if info, err := os.Stat(yamlFile); err == nil {
+			lastModified = info.ModTime()
+		}
+	}
+
 	for {
 		select {
 		case <-stopWatcher:
@@ -48,15 +54,16 @@ func watchMetadata(stopWatcher chan bool) {
 				metadataConfig.Summary = updatedMetadata.Summary
 				metadataConfig.Image = updatedMetadata.Image
 				metadataConfig.Tags = updatedMetadata.Tags
-				metadataMutex.Unlock()
 
 				// Save the updated metadata to JSON
 				if err := saveMetadata(metadataFile); err != nil {
 					log.Printf("Failed to save updated metadata: %v", err)
 				}
+				event := metadataConfig.liveEvent()
+				metadataMutex.Unlock()
 
 				// Broadcast Nostr event for metadata update
-				nostr.BroadcastNostrUpdateEvent(metadataFile)
+				nostr.BroadcastNostrUpdateEvent(event)
 
 				lastModified = modTime
 			}
@@ -75,19 +82,27 @@ func loadMetadata(filename string, dest *MetadataConfig) error {
 	return yaml.Unmarshal(file, dest)
 }
 
-// Save metadata as a JSON file
+// Save metadata as a JSON file, callers hold metadataMutex
 func saveMetadata(filename string) error {
-	metadataMap := structToMap(metadataConfig)
-
-	lowercaseMetadata := make(map[string]interface{})
-	for key, value := range metadataMap {
-		lowercaseKey := strings.ToLower(key)
-		lowercaseMetadata[lowercaseKey] = value
-	}
-
-	data, err := json.MarshalIndent(lowercaseMetadata, "", "  ")
+	data, err := json.MarshalIndent(metadataConfig, "", "  ")
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(filename, data, 0644)
 }
+
+// GetStreamData returns the stream metadata along with the live viewer counts
+func GetStreamData() MetadataConfig {
+	metadataMutex.Lock()
+	data := metadataConfig
+	metadataMutex.Unlock()
+
+	if data.Status == "" {
+		data.Status = "offline"
+	}
+
+	counts := GetViewerCounts()
+	data.CurrentParticipants = counts.Current
+	data.TotalParticipants = counts.Total
+	return data
+}
@@ -3,97 +3,190 @@ package stream
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
-	"goFrame/src/utils/stream/nostr"
+	"goFrame/src/utils"
 )
 
-// MonitorStream is the main function that handles the streaming process
+var (
+	stopWatcher chan bool
+	graceTimer  *time.Timer
+)
+
+// MonitorStream is the main function that handles the streaming process. A broken
+// stream configuration leaves ingest disabled, the rest of the server keeps running.
 func MonitorStream() {
 	if err := LoadStreamConfig("config.yml"); err != nil {
-		log.Fatalf("Error loading stream config: %v", err)
+		log.Printf("Live streaming disabled, error loading stream config: %v", err)
+		return
 	}
 	if err := LoadMetadataConfig("stream.yml"); err != nil {
-		log.Fatalf("Error loading metadata config: %v", err)
+		log.Printf("Live streaming disabled, error loading metadata config: %v", err)
+		return
 	}
+	// Stream events carry absolute media URLs, a localhost fallback would reach no viewer
+	if utils.AppConfig.Server.PublicURL == "" && utils.AppConfig.Server.MediaURL == "" {
+		log.Println("Live streaming disabled, set server.public_url or server.media_url in config.yml")
+		return
+	}
+
+	registerStateHooks()
+	startArchiveJobs()
 
+	// Publishers push to the embedded RTMP server, which reports start and stop here
+	go startIngestServer()
+
+	var graceExpired <-chan time.Time
 	for {
-		rtmpURL := streamConfig.RTMPStreamURL
-
-		if isStreamActive(rtmpURL) {
-			log.Println("Stream detected, starting HLS process...")
-
-			metadataMutex.Lock()
-			// Initialize stream metadata if this is a new stream
-			if metadataConfig.Status != "live" {
-				metadataConfig.Dtag = generateDtag()
-				metadataConfig.Ends = ""
-				metadataConfig.Starts = fmt.Sprintf("%d", time.Now().Unix())
-				metadataConfig.Status = "live"
-				metadataConfig.StreamURL = ("https://happytavern.co/live/output.m3u8")
-				metadataConfig.RecordingURL = fmt.Sprintf("https://happytavern.co/.videos/past-streams/%s-%s/output.m3u8",
-					time.Now().Format("1-2-2006"), metadataConfig.Dtag)
-
-				// Save to JSON immediately
-				saveMetadata("web/live/metadata.json")
-				log.Printf("MonitorStream: Initial StreamURL: %s", metadataConfig.StreamURL) //add log
-				nostr.BroadcastNostrStartEvent("web/live/metadata.json")
+		select {
+		case event := <-publishEvents:
+			if event.started {
+				beginStream()
+			} else {
+				endStream()
 			}
-			metadataMutex.Unlock()
+		case <-graceExpired:
+			finishStream()
+		}
 
-			// Create a channel to signal the metadata watcher to stop
-			stopWatcher := make(chan bool)
+		// The grace timer only exists while a stream is ending
+		graceExpired = nil
+		if graceTimer != nil {
+			graceExpired = graceTimer.C
+		}
+	}
+}
 
-			// Start watching metadata changes in a goroutine
-			go watchMetadata(stopWatcher) //add the nostr broadcast update to this function
+// beginStream starts encoding when a publisher connects, resuming the previous
+// event if the publisher dropped within the grace window
+func beginStream() {
+	switch lifecycle.current() {
+	case StateEnding:
+		graceTimer.Stop()
+		graceTimer = nil
+		log.Println("Publisher reconnected within the grace window, resuming stream...")
+		if err := lifecycle.transition(StateStarting, "publisher reconnected"); err != nil {
+			log.Printf("Failed to resume stream: %v", err)
+			return
+		}
 
-			// Start encoding the stream
-			startHLSStream()
+	case StateOffline:
+		log.Println("Stream detected, starting HLS process...")
 
-			// Wait for the stream to stop
-			log.Println("Beginning to monitor stream for inactivity...")
-			waitForStreamToStop(rtmpURL)
+		// A recording that failed to archive must not be overwritten
+		retryPendingArchive(true)
+		resetLiveWindows()
 
-			log.Println("Stream has been detected as inactive, beginning shutdown sequence...")
+		// Going live near a scheduled start continues that planned event
+		dtag := generateDtag()
+		scheduled := claimScheduledStream(time.Now())
+		boundToSchedule = scheduled != nil
+		if scheduled != nil {
+			log.Printf("Binding stream to scheduled event %s (%s)", scheduled.Dtag, scheduled.Title)
+			dtag = scheduled.Dtag
+		}
 
-			// Signal metadata watcher to stop
-			stopWatcher <- true
+		if err := lifecycle.begin(dtag, "publisher connected"); err != nil {
+			log.Printf("Failed to start stream: %v", err)
+			return
+		}
 
-			// Stop the HLS stream and perform cleanup
-			log.Println("Calling stopHLSStream function...")
-			stopHLSStream()
+		metadataMutex.Lock()
+		metadataConfig.Dtag = dtag
+		metadataConfig.Ends = ""
+		metadataConfig.Starts = fmt.Sprintf("%d", time.Now().Unix())
+		metadataConfig.Status = "live"
+		metadataConfig.CurrentParticipants = 0
+		metadataConfig.TotalParticipants = 0
+		if scheduled != nil {
+			metadataConfig.Title = scheduled.Title
+			metadataConfig.Summary = scheduled.Summary
+			metadataConfig.Image = scheduled.Image
+			metadataConfig.Tags = scheduled.Tags
+		}
+		metadataConfig.StreamURL = utils.MediaURL("/live/" + masterPlaylistName)
+		metadataConfig.RecordingURL = utils.MediaURL(
+			strings.TrimPrefix(archiveDir(dtag, lifecycle.snapshot().StartedAt), "web/") + "/" + masterPlaylistName)
+		metadataMutex.Unlock()
 
-			log.Println("Stream shutdown sequence completed")
+	default:
+		return
+	}
 
-		}
+	// Start encoding the stream, this also saves metadata.json
+	if err := startHLSStream(); err != nil {
+		reportStorageIssue("start encoder", liveDir, err)
+		endStream()
+		return
+	}
 
-		time.Sleep(5 * time.Second)
+	if err := lifecycle.transition(StateLive, "encoder started"); err != nil {
+		log.Printf("Failed to mark stream live: %v", err)
 	}
+
+	// The planned event is only used up once the stream is actually live
+	if boundToSchedule {
+		releaseScheduledStream(lifecycle.snapshot().Dtag)
+	}
+
+	// Create a channel to signal the metadata watcher to stop
+	stopWatcher = make(chan bool)
+
+	// Start watching metadata changes in a goroutine
+	// A scheduled event's details win until stream.yml is edited again
+	go watchMetadata(stopWatcher, boundToSchedule)
 }
 
-// waitForStreamToStop monitors the RTMP stream and returns when it detects the stream has stopped
-func waitForStreamToStop(rtmpStreamURL string) {
-	consecutiveInactiveChecks := 0
-	const maxInactiveChecks = 3 // Require 3 consecutive inactive checks to confirm stop
+// endStream stops encoding once the publisher has gone away and waits for a reconnect
+func endStream() {
+	state := lifecycle.current()
+	if state != StateLive && state != StateStarting {
+		return
+	}
 
-	for {
-		active := isStreamActive(rtmpStreamURL)
+	log.Println("Stream has been detected as inactive, beginning shutdown sequence...")
+	if err := lifecycle.transition(StateEnding, "publisher disconnected"); err != nil {
+		log.Printf("Failed to end stream: %v", err)
+		return
+	}
+
+	// Signal metadata watcher to stop, it only runs once the stream is live
+	if state == StateLive {
+		stopWatcher <- true
+	}
 
-		if !active {
-			consecutiveInactiveChecks++
-			log.Printf("Inactive check %d/%d", consecutiveInactiveChecks, maxInactiveChecks)
+	log.Println("Calling stopHLSStream function...")
+	stopHLSStream()
 
-			if consecutiveInactiveChecks >= maxInactiveChecks {
-				log.Println("Stream stopped after multiple inactive checks. Proceeding to cleanup...")
-				return // Exit the function to handle stream stop
-			}
-		} else {
-			if consecutiveInactiveChecks > 0 {
-				log.Println("Stream is active again, resetting inactive checks.")
-			}
-			consecutiveInactiveChecks = 0 // Reset if the stream becomes active again
-		}
+	grace := time.Duration(streamConfig.Lifecycle.ReconnectGrace) * time.Second
+	lifecycle.setGrace(time.Now().Add(grace))
+	graceTimer = time.NewTimer(grace)
+	log.Printf("Waiting %s for the publisher to reconnect before archiving", grace)
+}
+
+// finishStream ends the event and archives the recording after the grace window
+func finishStream() {
+	graceTimer = nil
+
+	metadataMutex.Lock()
+	log.Println("Updating metadata for stream end...")
+	metadataConfig.Status = "ended"
+	metadataConfig.Ends = fmt.Sprintf("%d", time.Now().Unix())
+	metadataConfig.CurrentParticipants = 0
+	metadataConfig.TotalParticipants = GetViewerCounts().Total
+	if err := saveMetadata(liveMetadataFile); err != nil {
+		log.Printf("Failed to save metadata: %v", err)
+	}
+	metadataMutex.Unlock()
 
-		time.Sleep(5 * time.Second)
+	// Hooks broadcast the end event and move the recording into past streams
+	if err := lifecycle.transition(StateArchived, "grace window expired"); err != nil {
+		log.Printf("Failed to archive stream: %v", err)
+		return
+	}
+	if err := lifecycle.transition(StateOffline, "stream archived"); err != nil {
+		log.Printf("Failed to reset stream state: %v", err)
 	}
+	log.Println("Stream shutdown sequence completed")
 }
@@ -0,0 +1,121 @@
+package nostr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const blossomAuthLifetime = 10 * time.Minute
+
+// blossomClient has no overall timeout, recordings can take a long time to upload
+var blossomClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 5 * time.Minute,
+	},
+}
+
+// BlobDescriptor is a Blossom server's description of a stored blob (BUD-02)
+type BlobDescriptor struct {
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Type     string `json:"type"`
+	Uploaded int64  `json:"uploaded"`
+}
+
+// BlossomAuthorization returns the Authorization header for a Blossom request,
+// a kind 24242 event scoped to one verb and blob (BUD-01)
+func BlossomAuthorization(verb, sha256Hex, content string) (string, error) {
+	tags := [][]string{
+		{"t", verb},
+		{"x", sha256Hex},
+		{"expiration", strconv.FormatInt(time.Now().Add(blossomAuthLifetime).Unix(), 10)},
+	}
+
+	event, err := createEvent(24242, content, tags)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// BlossomUpload stores a blob on a Blossom server and returns where it can be fetched
+func BlossomUpload(server string, body io.Reader, size int64, sha256Hex, mimeType, name string) (BlobDescriptor, error) {
+	auth, err := BlossomAuthorization("upload", sha256Hex, "Upload "+name)
+	if err != nil {
+		return BlobDescriptor{}, fmt.Errorf("sign authorization: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(server, "/")+"/upload", body)
+	if err != nil {
+		return BlobDescriptor{}, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", mimeType)
+	req.Header.Set("X-SHA-256", sha256Hex)
+
+	resp, err := blossomClient.Do(req)
+	if err != nil {
+		return BlobDescriptor{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Servers explain rejections in X-Reason (BUD-01)
+		reason := resp.Header.Get("X-Reason")
+		if reason == "" {
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			reason = strings.TrimSpace(string(data))
+		}
+		return BlobDescriptor{}, fmt.Errorf("%s rejected the upload: %s %s", server, resp.Status, reason)
+	}
+
+	var blob BlobDescriptor
+	if err := json.NewDecoder(resp.Body).Decode(&blob); err != nil {
+		return BlobDescriptor{}, fmt.Errorf("invalid blob descriptor from %s: %w", server, err)
+	}
+	if !strings.EqualFold(blob.SHA256, sha256Hex) {
+		return BlobDescriptor{}, fmt.Errorf("%s stored hash %s, expected %s", server, blob.SHA256, sha256Hex)
+	}
+	return blob, nil
+}
+
+// BlossomDelete removes a blob this server's key uploaded (BUD-02)
+func BlossomDelete(server, sha256Hex string) error {
+	auth, err := BlossomAuthorization("delete", sha256Hex, "Delete "+sha256Hex)
+	if err != nil {
+		return fmt.Errorf("sign authorization: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, strings.TrimSuffix(server, "/")+"/"+sha256Hex, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+
+	resp, err := blossomClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Already gone is as good as deleted
+	if resp.StatusCode == http.StatusNotFound || (resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return nil
+	}
+	reason := resp.Header.Get("X-Reason")
+	return fmt.Errorf("%s refused to delete %s: %s %s", server, sha256Hex, resp.Status, reason)
+}
@@ -0,0 +1,257 @@
+package nostr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blossomStandIn is a minimal BUD-01/02 server that checks every authorization event
+type blossomStandIn struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	blobs map[string][]byte
+	auths []Event // Authorization events in the order they arrived
+}
+
+func newBlossomStandIn(t *testing.T) *blossomStandIn {
+	s := &blossomStandIn{blobs: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *blossomStandIn) handle(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/upload":
+		body, _ := io.ReadAll(r.Body)
+		hash := sha256Hex(body)
+		if err := s.authorize(r, "upload", hash); err != nil {
+			s.reject(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if r.Header.Get("X-SHA-256") != hash {
+			s.reject(w, http.StatusBadRequest, "X-SHA-256 does not match the body")
+			return
+		}
+
+		s.mu.Lock()
+		s.blobs[hash] = body
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(BlobDescriptor{
+			URL:      s.URL + "/" + hash,
+			SHA256:   hash,
+			Size:     int64(len(body)),
+			Type:     r.Header.Get("Content-Type"),
+			Uploaded: time.Now().Unix(),
+		})
+
+	case r.Method == http.MethodDelete:
+		hash := strings.TrimPrefix(r.URL.Path, "/")
+		if err := s.authorize(r, "delete", hash); err != nil {
+			s.reject(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		s.mu.Lock()
+		_, ok := s.blobs[hash]
+		delete(s.blobs, hash)
+		s.mu.Unlock()
+		if !ok {
+			s.reject(w, http.StatusNotFound, "blob not found")
+		}
+
+	default:
+		s.reject(w, http.StatusMethodNotAllowed, "not supported")
+	}
+}
+
+func (s *blossomStandIn) reject(w http.ResponseWriter, status int, reason string) {
+	w.Header().Set("X-Reason", reason)
+	w.WriteHeader(status)
+}
+
+// authorize checks a kind 24242 event the way BUD-01 asks servers to
+func (s *blossomStandIn) authorize(r *http.Request, verb, hash string) error {
+	encoded, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Nostr ")
+	if !ok {
+		return fmt.Errorf("missing authorization")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	if err := VerifyEvent(&event); err != nil {
+		return err
+	}
+	if event.Kind != 24242 {
+		return fmt.Errorf("kind %d", event.Kind)
+	}
+
+	var verbs, hashes []string
+	var expiration int64
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "t":
+			verbs = append(verbs, tag[1])
+		case "x":
+			hashes = append(hashes, tag[1])
+		case "expiration":
+			expiration, _ = strconv.ParseInt(tag[1], 10, 64)
+		}
+	}
+	if len(verbs) != 1 || verbs[0] != verb {
+		return fmt.Errorf("authorization is for %v, not %s", verbs, verb)
+	}
+	if len(hashes) != 1 || hashes[0] != hash {
+		return fmt.Errorf("authorization is for blob %v, not %s", hashes, hash)
+	}
+	if expiration <= time.Now().Unix() {
+		return fmt.Errorf("authorization expired")
+	}
+
+	s.mu.Lock()
+	s.auths = append(s.auths, event)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *blossomStandIn) blob(hash string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[hash]
+	return data, ok
+}
+
+func TestBlossomUploadAndMirror(t *testing.T) {
+	primary := newBlossomStandIn(t)
+	mirror := newBlossomStandIn(t)
+
+	recording := []byte("not really an mp4, but a blob is a blob")
+	hash := sha256Hex(recording)
+
+	// A recording is uploaded to every configured server in turn
+	for _, server := range []*blossomStandIn{primary, mirror} {
+		blob, err := BlossomUpload(server.URL+"/", bytes.NewReader(recording), int64(len(recording)), hash, "video/mp4", "recording.mp4")
+		if err != nil {
+			t.Fatalf("upload to %s: %v", server.URL, err)
+		}
+		if blob.URL != server.URL+"/"+hash {
+			t.Errorf("URL = %s, want %s/%s", blob.URL, server.URL, hash)
+		}
+		if blob.Size != int64(len(recording)) || blob.Type != "video/mp4" {
+			t.Errorf("descriptor = %+v", blob)
+		}
+
+		stored, ok := server.blob(hash)
+		if !ok || !bytes.Equal(stored, recording) {
+			t.Errorf("%s did not store the recording", server.URL)
+		}
+	}
+
+	// Both copies were authorized by our key for exactly this blob
+	for _, server := range []*blossomStandIn{primary, mirror} {
+		if len(server.auths) != 1 {
+			t.Fatalf("%s saw %d authorizations, want 1", server.URL, len(server.auths))
+		}
+		auth := server.auths[0]
+		if auth.PubKey != publicKey {
+			t.Errorf("signed by %s, want %s", auth.PubKey, publicKey)
+		}
+		if auth.Content != "Upload recording.mp4" {
+			t.Errorf("content = %q", auth.Content)
+		}
+	}
+}
+
+func TestBlossomUploadRejected(t *testing.T) {
+	server := newBlossomStandIn(t)
+
+	// The announced hash doesn't match the body, so the authorization doesn't either
+	body := []byte("segment")
+	_, err := BlossomUpload(server.URL, bytes.NewReader(body), int64(len(body)), sha256Hex([]byte("other")), "video/mp2t", "segment0.ts")
+	if err == nil {
+		t.Fatal("upload with the wrong hash succeeded")
+	}
+	if !strings.Contains(err.Error(), "authorization is for blob") {
+		t.Errorf("error does not carry the X-Reason: %v", err)
+	}
+}
+
+func TestBlossomDelete(t *testing.T) {
+	server := newBlossomStandIn(t)
+
+	body := []byte("clip")
+	hash := sha256Hex(body)
+	if _, err := BlossomUpload(server.URL, bytes.NewReader(body), int64(len(body)), hash, "video/mp4", "clip.mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := BlossomDelete(server.URL, hash); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := server.blob(hash); ok {
+		t.Error("blob still stored after delete")
+	}
+
+	// Deleting again finds nothing, which counts as deleted
+	if err := BlossomDelete(server.URL, hash); err != nil {
+		t.Errorf("second delete: %v", err)
+	}
+}
+
+func TestBlossomAuthorization(t *testing.T) {
+	hash := sha256Hex([]byte("blob"))
+	header, err := BlossomAuthorization("upload", hash, "Upload blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Nostr "))
+	if err != nil {
+		t.Fatalf("header is not base64: %v", err)
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyEvent(&event); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	if event.Kind != 24242 {
+		t.Errorf("kind = %d, want 24242", event.Kind)
+	}
+
+	if len(event.Tags) != 3 {
+		t.Fatalf("tags = %v, want t, x and expiration", event.Tags)
+	}
+	for i, want := range [][]string{{"t", "upload"}, {"x", hash}} {
+		if strings.Join(event.Tags[i], ",") != strings.Join(want, ",") {
+			t.Errorf("tag %d = %v, want %v", i, event.Tags[i], want)
+		}
+	}
+
+	expires := time.Now().Add(blossomAuthLifetime).Unix()
+	expiration, _ := strconv.ParseInt(event.Tags[2][1], 10, 64)
+	if event.Tags[2][0] != "expiration" || expiration < expires-5 || expiration > expires {
+		t.Errorf("expiration tag = %v, want about %d", event.Tags[2], expires)
+	}
+}
@@ -0,0 +1,27 @@
+package nostr
+
+import (
+	"log"
+)
+
+// Broadcasts a Nostr event announcing a scheduled stream
+func BroadcastNostrPlannedEvent(event LiveEvent) {
+	event.Status = "planned"
+	logBroadcast("planned", broadcastLiveEvent(event))
+}
+
+// Broadcasts a NIP-09 deletion request for one of our live events
+func BroadcastNostrDeleteEvent(dtag, reason string) {
+	tags := [][]string{
+		{"a", LiveEventCoordinate(dtag)},
+		{"k", "30311"},
+	}
+
+	event, err := createEvent(5, reason, tags)
+	if err != nil {
+		log.Printf("Error creating deletion event: %v", err)
+		return
+	}
+
+	sendEvent(event)
+}
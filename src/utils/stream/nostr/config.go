@@ -3,7 +3,6 @@ package nostr
 import (
 	"encoding/hex"
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/btcsuite/btcd/btcec/v2"
@@ -26,22 +25,17 @@ type Config struct {
 	PrivateKey string   `yaml:"private_key"`
 	PublicKey  string   `yaml:"public_key"`
 	Relays     []string `yaml:"relays"`
+	ChatRelays []string `yaml:"chat_relays"` // Relays read for live chat, defaults to relays
 }
 
 var (
 	privateKey *btcec.PrivateKey
 	publicKey  string
 	relays     []string
+	chatRelays []string
 )
 
-// Automatically loads config when the package is initialized
-func init() {
-	if err := LoadConfig("nostr.yml"); err != nil {
-		log.Fatalf("Failed to load Nostr config: %v", err)
-	}
-}
-
-// LoadConfig reads nostr.yml and loads the configuration
+// LoadConfig reads nostr.yml and loads the configuration, main calls it on startup
 func LoadConfig(configFile string) error {
 	data, err := os.ReadFile(configFile)
 	if err != nil {
@@ -66,5 +60,6 @@ func LoadConfig(configFile string) error {
 	publicKey = fmt.Sprintf("%x", publicKeyBytes)
 
 	relays = cfg.Relays
+	chatRelays = cfg.ChatRelays
 	return nil
 }
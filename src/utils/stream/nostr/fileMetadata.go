@@ -0,0 +1,65 @@
+package nostr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// FileMetadata describes a hosted file as a NIP-94 kind 1063 event
+type FileMetadata struct {
+	URL       string
+	MIME      string
+	SHA256    string // Hex digest of the file as served
+	Original  string // Hex digest before the server changed it, e.g. by stripping metadata
+	Size      int64
+	Dim       string // "<width>x<height>"
+	Blurhash  string
+	Thumb     string
+	Image     string
+	Summary   string
+	Alt       string
+	Content   string // Caption shown by clients
+	LiveEvent string // Optional "30311:<pubkey>:<d>" coordinate the file was cut from
+}
+
+// BuildTags returns the kind 1063 tag set, empty optional values are left out
+func (f FileMetadata) BuildTags() [][]string {
+	tags := [][]string{}
+
+	add := func(name, value string) {
+		if value != "" {
+			tags = append(tags, []string{name, value})
+		}
+	}
+	add("url", f.URL)
+	add("m", f.MIME)
+	add("x", f.SHA256)
+	add("ox", f.Original)
+	if f.Size > 0 {
+		add("size", strconv.FormatInt(f.Size, 10))
+	}
+	add("dim", f.Dim)
+	add("blurhash", f.Blurhash)
+	add("thumb", f.Thumb)
+	add("image", f.Image)
+	add("summary", f.Summary)
+	add("alt", f.Alt)
+	add("a", f.LiveEvent)
+	return tags
+}
+
+// BroadcastFileMetadata signs the file metadata event, sends it to every relay
+// in the background and returns its ID
+func BroadcastFileMetadata(f FileMetadata) (string, error) {
+	if f.URL == "" || f.MIME == "" || f.SHA256 == "" {
+		return "", fmt.Errorf("file metadata needs url, m and x")
+	}
+
+	event, err := createEvent(1063, f.Content, f.BuildTags())
+	if err != nil {
+		return "", err
+	}
+
+	go sendEvent(event)
+	return event.ID, nil
+}
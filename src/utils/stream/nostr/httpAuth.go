@@ -0,0 +1,83 @@
+package nostr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// httpAuthWindow is how far an HTTP auth event's created_at may be from now (NIP-98)
+const httpAuthWindow = time.Minute
+
+// ErrNoHTTPAuth is returned when a request carries no NIP-98 Authorization header
+var ErrNoHTTPAuth = errors.New("no nostr authorization")
+
+// HTTPAuth is a verified NIP-98 authorization
+type HTTPAuth struct {
+	PubKey  string
+	Payload string // Hex SHA-256 of the request body the event was signed for, if it names one
+}
+
+// CheckPayload compares the hex SHA-256 of the request body with the payload tag, a
+// request signed without one passes
+func (a HTTPAuth) CheckPayload(bodySHA256 string) error {
+	if a.Payload != "" && !strings.EqualFold(a.Payload, bodySHA256) {
+		return fmt.Errorf("authorization is for a body with SHA-256 %s, not %s", a.Payload, bodySHA256)
+	}
+	return nil
+}
+
+// VerifyHTTPAuth checks a NIP-98 "Nostr <base64 event>" Authorization header against the
+// request's absolute URL and method. The body can only be checked once it has been read,
+// with CheckPayload.
+func VerifyHTTPAuth(header, method, url string) (HTTPAuth, error) {
+	encoded, ok := strings.CutPrefix(header, "Nostr ")
+	if !ok {
+		return HTTPAuth{}, ErrNoHTTPAuth
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return HTTPAuth{}, fmt.Errorf("invalid authorization encoding: %w", err)
+	}
+
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return HTTPAuth{}, fmt.Errorf("invalid authorization event: %w", err)
+	}
+	if event.Kind != 27235 {
+		return HTTPAuth{}, fmt.Errorf("authorization event has kind %d, expected 27235", event.Kind)
+	}
+	if age := time.Since(time.Unix(event.CreatedAt, 0)); age > httpAuthWindow || age < -httpAuthWindow {
+		return HTTPAuth{}, fmt.Errorf("authorization event is too old or in the future")
+	}
+
+	var signedURL, signedMethod, payload string
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "u":
+			signedURL = tag[1]
+		case "method":
+			signedMethod = tag[1]
+		case "payload":
+			payload = tag[1]
+		}
+	}
+	// Clients disagree on trailing slashes, nothing else may differ
+	if strings.TrimSuffix(signedURL, "/") != strings.TrimSuffix(url, "/") {
+		return HTTPAuth{}, fmt.Errorf("authorization is for %s, not %s", signedURL, url)
+	}
+	if !strings.EqualFold(signedMethod, method) {
+		return HTTPAuth{}, fmt.Errorf("authorization is for %s, not %s", signedMethod, method)
+	}
+
+	if err := VerifyEvent(&event); err != nil {
+		return HTTPAuth{}, err
+	}
+	return HTTPAuth{PubKey: event.PubKey, Payload: payload}, nil
+}
@@ -0,0 +1,71 @@
+package nostr
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const uploadURL = "https://example.com/api/nip96"
+
+// httpAuthHeader signs a NIP-98 Authorization header with the test key
+func httpAuthHeader(t *testing.T, tags ...[]string) string {
+	event, err := createEvent(27235, "", append([][]string{{"u", uploadURL}, {"method", "POST"}}, tags...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestVerifyHTTPAuthPayload(t *testing.T) {
+	body := []byte("--boundary\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.txt\"\r\n\r\nhello\r\n--boundary--\r\n")
+	hash := sha256Hex(body)
+
+	auth, err := VerifyHTTPAuth(httpAuthHeader(t, []string{"payload", hash}), http.MethodPost, uploadURL)
+	if err != nil {
+		t.Fatalf("VerifyHTTPAuth: %v", err)
+	}
+	if auth.PubKey != publicKey || auth.Payload != hash {
+		t.Errorf("auth = %+v", auth)
+	}
+	if err := auth.CheckPayload(hash); err != nil {
+		t.Errorf("matching body: %v", err)
+	}
+	if err := auth.CheckPayload(strings.ToUpper(hash)); err != nil {
+		t.Errorf("matching body in upper case: %v", err)
+	}
+	if err := auth.CheckPayload(sha256Hex([]byte("something else"))); err == nil {
+		t.Error("a different body passed the payload check")
+	}
+
+	// Without a payload tag the body isn't covered by the signature
+	auth, err = VerifyHTTPAuth(httpAuthHeader(t), http.MethodPost, uploadURL)
+	if err != nil {
+		t.Fatalf("VerifyHTTPAuth without payload: %v", err)
+	}
+	if err := auth.CheckPayload(hash); err != nil {
+		t.Errorf("request without a payload tag: %v", err)
+	}
+}
+
+func TestVerifyHTTPAuthRejects(t *testing.T) {
+	header := httpAuthHeader(t)
+	if _, err := VerifyHTTPAuth(header, http.MethodDelete, uploadURL); err == nil {
+		t.Error("authorization for POST accepted for DELETE")
+	}
+	if _, err := VerifyHTTPAuth(header, http.MethodPost, uploadURL+"/other"); err == nil {
+		t.Error("authorization accepted for another URL")
+	}
+	if _, err := VerifyHTTPAuth(header, http.MethodPost, uploadURL+"/"); err != nil {
+		t.Errorf("trailing slash: %v", err)
+	}
+	if _, err := VerifyHTTPAuth("Bearer token", http.MethodPost, uploadURL); err != ErrNoHTTPAuth {
+		t.Errorf("non-Nostr header = %v, want ErrNoHTTPAuth", err)
+	}
+}
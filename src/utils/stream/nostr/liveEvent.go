@@ -0,0 +1,87 @@
+package nostr
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// LiveEvent is the metadata of a NIP-53 kind 30311 live event
+type LiveEvent struct {
+	Dtag                string
+	Host                string // Hex pubkey tagged as the host, defaults to our own key
+	Title               string
+	Summary             string
+	Image               string
+	Tags                []string
+	StreamURL           string
+	RecordingURL        string
+	RecordingMirrors    []string // Further copies of the recording, e.g. on Blossom servers
+	Starts              string   // Unix timestamp
+	Ends                string   // Unix timestamp
+	Status              string   // "planned", "live" or "ended"
+	CurrentParticipants int
+	TotalParticipants   int
+}
+
+// BuildTags returns the kind 30311 tag set. Empty optional values are left out
+// and participant counts are only included once someone has watched.
+func (e LiveEvent) BuildTags() [][]string {
+	tags := [][]string{{"d", e.Dtag}}
+
+	add := func(name, value string) {
+		if value != "" {
+			tags = append(tags, []string{name, value})
+		}
+	}
+	add("title", e.Title)
+	add("summary", e.Summary)
+	add("image", e.Image)
+	add("streaming", e.StreamURL)
+	add("recording", e.RecordingURL)
+	for _, mirror := range e.RecordingMirrors {
+		add("recording", mirror)
+	}
+	add("starts", e.Starts)
+	add("ends", e.Ends)
+	add("status", e.Status)
+	if e.Host != "" {
+		tags = append(tags, []string{"p", e.Host, "", "Host"})
+	}
+
+	if e.CurrentParticipants > 0 || e.TotalParticipants > 0 {
+		add("current_participants", strconv.Itoa(e.CurrentParticipants))
+		add("total_participants", strconv.Itoa(e.TotalParticipants))
+	}
+
+	for _, tag := range e.Tags {
+		add("t", tag)
+	}
+	return tags
+}
+
+// broadcastLiveEvent signs the live event and sends it to every relay
+func broadcastLiveEvent(e LiveEvent) error {
+	if e.Dtag == "" {
+		return fmt.Errorf("live event has no d tag")
+	}
+
+	if e.Host == "" {
+		e.Host = publicKey
+	}
+
+	event, err := createEvent(30311, "", e.BuildTags())
+	if err != nil {
+		return err
+	}
+
+	sendEvent(event)
+	return nil
+}
+
+// logBroadcast reports a failed broadcast, the stream keeps running either way
+func logBroadcast(kind string, err error) {
+	if err != nil {
+		log.Printf("Error creating %s event: %v", kind, err)
+	}
+}
@@ -0,0 +1,125 @@
+package nostr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// parseLiveEvent reads a kind 30311 tag set back into a LiveEvent
+func parseLiveEvent(tags [][]string) LiveEvent {
+	var e LiveEvent
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "d":
+			e.Dtag = tag[1]
+		case "title":
+			e.Title = tag[1]
+		case "summary":
+			e.Summary = tag[1]
+		case "image":
+			e.Image = tag[1]
+		case "streaming":
+			e.StreamURL = tag[1]
+		case "recording":
+			if e.RecordingURL == "" {
+				e.RecordingURL = tag[1]
+			} else {
+				e.RecordingMirrors = append(e.RecordingMirrors, tag[1])
+			}
+		case "starts":
+			e.Starts = tag[1]
+		case "ends":
+			e.Ends = tag[1]
+		case "status":
+			e.Status = tag[1]
+		case "current_participants":
+			e.CurrentParticipants, _ = strconv.Atoi(tag[1])
+		case "total_participants":
+			e.TotalParticipants, _ = strconv.Atoi(tag[1])
+		case "t":
+			e.Tags = append(e.Tags, tag[1])
+		case "p":
+			if len(tag) >= 4 && tag[3] == "Host" {
+				e.Host = tag[1]
+			}
+		}
+	}
+	return e
+}
+
+func TestLiveEventRoundTrip(t *testing.T) {
+	base := LiveEvent{
+		Dtag:      "a1b2c3",
+		Host:      publicKey,
+		Title:     "Building a relay",
+		Summary:   "Live coding",
+		Image:     "https://example.com/poster.jpg",
+		Tags:      []string{"nostr", "golang"},
+		StreamURL: "https://example.com/live/master.m3u8",
+		Starts:    "1760000000",
+	}
+
+	start := base
+	start.Status = "live"
+
+	update := start
+	update.Title = "Building a relay, part two"
+	update.CurrentParticipants = 12
+	update.TotalParticipants = 30
+
+	end := update
+	end.Status = "ended"
+	end.Ends = "1760003600"
+	end.CurrentParticipants = 0
+	end.RecordingURL = "https://example.com/past-streams/a1b2c3/master.m3u8"
+	end.RecordingMirrors = []string{"https://blossom.example.com/abc.mp4"}
+
+	tests := []struct {
+		name  string
+		event LiveEvent
+	}{
+		{"start", start},
+		{"update", update},
+		{"end", end},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := createEvent(30311, "", tt.event.BuildTags())
+			if err != nil {
+				t.Fatalf("createEvent: %v", err)
+			}
+
+			// Go through JSON as a relay would
+			data, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var received Event
+			if err := json.Unmarshal(data, &received); err != nil {
+				t.Fatal(err)
+			}
+			if received.Kind != 30311 {
+				t.Errorf("kind = %d, want 30311", received.Kind)
+			}
+
+			got := parseLiveEvent(received.Tags)
+			if !reflect.DeepEqual(got, tt.event) {
+				t.Errorf("round trip mismatch\n got %+v\nwant %+v", got, tt.event)
+			}
+		})
+	}
+}
+
+func TestBuildTagsLeavesOutEmptyValues(t *testing.T) {
+	tags := LiveEvent{Dtag: "planned", Title: "Soon", Status: "planned"}.BuildTags()
+
+	want := [][]string{{"d", "planned"}, {"title", "Soon"}, {"status", "planned"}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("BuildTags() = %v, want %v", tags, want)
+	}
+}
@@ -33,6 +33,10 @@ func connectToRelay(relay string) (*websocket.Conn, error) {
 }
 
 func createEvent(kind int, content string, tags [][]string) (*Event, error) {
+	if privateKey == nil {
+		return nil, fmt.Errorf("no nostr key loaded")
+	}
+
 	event := Event{
 		PubKey:    publicKey,
 		CreatedAt: time.Now().Unix(),
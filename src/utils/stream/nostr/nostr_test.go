@@ -0,0 +1,54 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// testPrivateKey signs every event made in tests, it is not used anywhere else
+var testPrivateKey = hex.EncodeToString(bytes.Repeat([]byte{0x11}, 32))
+
+// TestMain signs with a throwaway config instead of the nostr.yml main loads
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "nostr-test")
+	if err != nil {
+		fmt.Println("Failed to create test config dir:", err)
+		os.Exit(1)
+	}
+
+	code := 1
+	if err := loadTestConfig(dir); err != nil {
+		fmt.Println("Failed to load test config:", err)
+	} else {
+		code = m.Run()
+	}
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func loadTestConfig(dir string) error {
+	keyBytes, _ := hex.DecodeString(testPrivateKey)
+	key, _ := btcec.PrivKeyFromBytes(keyBytes)
+
+	config := fmt.Sprintf("private_key: %q\npublic_key: %q\nrelays: []\n",
+		testPrivateKey, hex.EncodeToString(schnorr.SerializePubKey(key.PubKey())))
+	configFile := filepath.Join(dir, "nostr.yml")
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		return err
+	}
+	return LoadConfig(configFile)
+}
@@ -0,0 +1,219 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	maxResubscribeBackoff = 2 * time.Minute
+	relayReadTimeout      = 5 * time.Minute // Relays that stay silent this long are reconnected
+)
+
+// Filter is a NIP-01 subscription filter
+type Filter struct {
+	Kinds []int    `json:"kinds,omitempty"`
+	ATags []string `json:"#a,omitempty"`
+	Since int64    `json:"since,omitempty"`
+	Limit int      `json:"limit,omitempty"`
+}
+
+// Subscription receives events from every chat relay until Close is called
+type Subscription struct {
+	stop chan struct{}
+}
+
+// Close ends the subscription on all relays
+func (s *Subscription) Close() {
+	close(s.stop)
+}
+
+// PublicKey returns the hex public key our events are signed with
+func PublicKey() string {
+	return publicKey
+}
+
+// LiveEventCoordinate returns the a tag value of our kind 30311 event with the given d tag
+func LiveEventCoordinate(dtag string) string {
+	return fmt.Sprintf("30311:%s:%s", publicKey, dtag)
+}
+
+// Subscribe opens the filter on every chat relay and calls onEvent for each valid event.
+// Relays are reconnected with backoff, the same event may arrive from several relays.
+func Subscribe(filter Filter, onEvent func(Event)) *Subscription {
+	sub := &Subscription{stop: make(chan struct{})}
+
+	targets := chatRelays
+	if len(targets) == 0 {
+		targets = relays
+	}
+	for _, relay := range targets {
+		go sub.run(relay, filter, onEvent)
+	}
+	return sub
+}
+
+func (s *Subscription) run(relay string, filter Filter, onEvent func(Event)) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		if err := s.listen(relay, &filter, onEvent); err != nil {
+			log.Printf("Subscription to %s ended: %v", relay, err)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
+
+// listen holds one REQ open on a relay until the connection fails or the subscription closes
+func (s *Subscription) listen(relay string, filter *Filter, onEvent func(Event)) error {
+	conn, err := connectToRelay(relay)
+	if err != nil {
+		return err
+	}
+
+	// Close the connection to unblock the read loop when we are stopped
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stop:
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	subID := newSubscriptionID()
+	req, err := json.Marshal([]interface{}{"REQ", subID, filter})
+	if err != nil {
+		return err
+	}
+	if err := websocket.Message.Send(conn, string(req)); err != nil {
+		return err
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(relayReadTimeout))
+
+		var raw string
+		if err := websocket.Message.Receive(conn, &raw); err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+				return err
+			}
+		}
+
+		var msg []json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil || len(msg) == 0 {
+			continue
+		}
+
+		var msgType string
+		json.Unmarshal(msg[0], &msgType)
+
+		switch msgType {
+		case "EVENT":
+			if len(msg) < 3 {
+				continue
+			}
+			var event Event
+			if err := json.Unmarshal(msg[2], &event); err != nil {
+				continue
+			}
+			if err := VerifyEvent(&event); err != nil {
+				log.Printf("Dropping invalid event from %s: %v", relay, err)
+				continue
+			}
+			// Resubscribing after a reconnect only needs what was missed
+			if event.CreatedAt > filter.Since {
+				filter.Since = event.CreatedAt
+			}
+			onEvent(event)
+		case "CLOSED":
+			return fmt.Errorf("relay closed subscription: %s", raw)
+		case "NOTICE":
+			log.Printf("Notice from %s: %s", relay, raw)
+		}
+	}
+}
+
+// VerifyEvent checks an event's ID and Schnorr signature
+func VerifyEvent(event *Event) error {
+	serialized := []interface{}{
+		0,
+		event.PubKey,
+		event.CreatedAt,
+		event.Kind,
+		event.Tags,
+		event.Content,
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := json.NewEncoder(buffer)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(serialized); err != nil {
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	hash := sha256.Sum256(bytes.TrimSpace(buffer.Bytes()))
+	if hex.EncodeToString(hash[:]) != event.ID {
+		return fmt.Errorf("event id does not match its content")
+	}
+
+	pubkeyBytes, err := hex.DecodeString(event.PubKey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	pubkey, err := schnorr.ParsePubKey(pubkeyBytes)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+
+	sigBytes, err := hex.DecodeString(event.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	if !sig.Verify(hash[:], pubkey) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+func newSubscriptionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
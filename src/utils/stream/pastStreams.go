@@ -0,0 +1,409 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+const (
+	pastStreamsDir     = "web/.videos/past-streams"
+	manifestName       = "manifest.json"
+	posterName         = "poster.jpg"
+	posterTimeout      = 30 * time.Second
+	defaultPastPerPage = 12
+	maxPastPerPage     = 100
+)
+
+// PastStream describes one archived recording
+type PastStream struct {
+	Folder       string   `json:"folder"`
+	Dtag         string   `json:"dtag"`
+	Title        string   `json:"title"`
+	Summary      string   `json:"summary"`
+	Image        string   `json:"image"`
+	Tags         []string `json:"tags"`
+	Starts       string   `json:"starts"`
+	Ends         string   `json:"ends"`
+	Duration     float64  `json:"duration"` // Seconds of recorded media
+	SegmentCount int      `json:"segment_count"`
+	Size         int64    `json:"size"` // Bytes on disk, every rendition included
+	Playlist     string   `json:"playlist"`
+	Poster       string   `json:"poster"`
+	RecordingURL string   `json:"recording_url"`
+
+	// Set once the post-archive remux has finished
+	MP4             string `json:"mp4"`
+	MP4Size         int64  `json:"mp4_size"`
+	SHA256          string `json:"sha256"` // Hex digest of the MP4
+	SegmentsDeleted bool   `json:"segments_deleted"`
+
+	Pinned bool `json:"pinned"` // Exempt from retention
+
+	Blossom []BlossomCopy `json:"blossom"` // Copies uploaded to Blossom servers
+}
+
+// PastStreamQuery selects a page of the catalog
+type PastStreamQuery struct {
+	Page    int
+	PerPage int
+	Search  string // Case-insensitive match on title and summary
+	Tag     string
+}
+
+// PastStreamPage is one page of the catalog, newest first
+type PastStreamPage struct {
+	Streams    []PastStream `json:"streams"`
+	Page       int          `json:"page"`
+	PerPage    int          `json:"per_page"`
+	Total      int          `json:"total"`
+	TotalPages int          `json:"total_pages"`
+}
+
+var pastStreams = struct {
+	sync.Mutex
+	loaded  bool
+	modTime time.Time
+	all     []PastStream
+}{}
+
+// ListPastStreams returns the archived recordings matching the query
+func ListPastStreams(q PastStreamQuery) (PastStreamPage, error) {
+	all, err := loadPastStreams()
+	if err != nil {
+		return PastStreamPage{}, err
+	}
+
+	search := strings.ToLower(strings.TrimSpace(q.Search))
+	tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q.Tag), "#"))
+
+	matches := []PastStream{}
+	for _, s := range all {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(s.Title), search) &&
+			!strings.Contains(strings.ToLower(s.Summary), search) {
+			continue
+		}
+		if tag != "" && !hasStreamTag(s.Tags, tag) {
+			continue
+		}
+		matches = append(matches, s)
+	}
+
+	if q.PerPage <= 0 {
+		q.PerPage = defaultPastPerPage
+	}
+	if q.PerPage > maxPastPerPage {
+		q.PerPage = maxPastPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	page := PastStreamPage{
+		Streams:    []PastStream{},
+		Page:       q.Page,
+		PerPage:    q.PerPage,
+		Total:      len(matches),
+		TotalPages: (len(matches) + q.PerPage - 1) / q.PerPage,
+	}
+	start := (q.Page - 1) * q.PerPage
+	if start < len(matches) {
+		end := min(start+q.PerPage, len(matches))
+		page.Streams = matches[start:end]
+	}
+	return page, nil
+}
+
+// loadPastStreams reads every manifest, re-reading only when the archive folder changes
+func loadPastStreams() ([]PastStream, error) {
+	pastStreams.Lock()
+	defer pastStreams.Unlock()
+
+	info, err := os.Stat(pastStreamsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pastStreams.loaded && info.ModTime().Equal(pastStreams.modTime) {
+		return pastStreams.all, nil
+	}
+
+	entries, err := os.ReadDir(pastStreamsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []PastStream
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		folder := filepath.Join(pastStreamsDir, entry.Name())
+
+		manifest, err := readManifest(folder)
+		if err != nil {
+			// Recordings archived before manifests existed get one built on first read
+			manifest, err = writeManifest(folder)
+			if err != nil {
+				log.Printf("Skipping past stream %s: %v", entry.Name(), err)
+				continue
+			}
+		}
+		all = append(all, withURLs(manifest))
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return pastStreamTime(all[i]) > pastStreamTime(all[j])
+	})
+
+	pastStreams.all = all
+	pastStreams.modTime = info.ModTime()
+	pastStreams.loaded = true
+	return all, nil
+}
+
+// invalidatePastStreams forces the next listing to re-read the manifests
+func invalidatePastStreams() {
+	pastStreams.Lock()
+	pastStreams.loaded = false
+	pastStreams.Unlock()
+}
+
+// catalogArchive writes the manifest and poster for a freshly archived recording, then queues the MP4 remux
+func catalogArchive(folder string) {
+	defer invalidatePastStreams()
+
+	playlist, entries, err := recordingPlaylist(folder)
+	if err != nil {
+		log.Printf("No playlist found in %s: %v", folder, err)
+	} else if err := generatePoster(folder, playlist, entries); err != nil {
+		log.Printf("Failed to generate poster for %s: %v", folder, err)
+	}
+
+	if _, err := writeManifest(folder); err != nil {
+		reportStorageIssue("manifest", folder, err)
+		return
+	}
+
+	if streamConfig.Archive.RemuxMP4 {
+		go func() {
+			remuxArchive(folder)
+			publishToBlossom(folder)
+		}()
+	} else {
+		go publishToBlossom(folder)
+	}
+}
+
+// writeManifest describes an archive folder from its metadata and playlists
+func writeManifest(folder string) (PastStream, error) {
+	var meta MetadataConfig
+	if err := loadMetadata(filepath.Join(folder, "metadata.json"), &meta); err != nil {
+		return PastStream{}, err
+	}
+
+	manifest := PastStream{
+		Folder:       filepath.Base(folder),
+		Dtag:         meta.Dtag,
+		Title:        meta.Title,
+		Summary:      meta.Summary,
+		Image:        meta.Image,
+		Tags:         meta.Tags,
+		Starts:       meta.Starts,
+		Ends:         meta.Ends,
+		RecordingURL: meta.RecordingURL,
+	}
+	if manifest.Tags == nil {
+		manifest.Tags = []string{}
+	}
+	if previous, err := readManifest(folder); err == nil {
+		manifest.MP4 = previous.MP4
+		manifest.MP4Size = previous.MP4Size
+		manifest.SHA256 = previous.SHA256
+		manifest.SegmentsDeleted = previous.SegmentsDeleted
+		manifest.Pinned = previous.Pinned
+		manifest.Blossom = previous.Blossom
+	}
+
+	if playlist, entries, err := recordingPlaylist(folder); err == nil {
+		manifest.Playlist = filepath.ToSlash(mustRel(folder, playlist))
+		manifest.SegmentCount = len(entries)
+		manifest.Duration = playlistDuration(entries)
+	}
+	if _, err := os.Stat(filepath.Join(folder, posterName)); err == nil {
+		manifest.Poster = posterName
+	}
+	manifest.Size = folderSize(folder)
+
+	if err := saveManifest(folder, manifest); err != nil {
+		return PastStream{}, err
+	}
+	return manifest, nil
+}
+
+func saveManifest(folder string, manifest PastStream) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(folder, manifestName), data, 0644)
+}
+
+func readManifest(folder string) (PastStream, error) {
+	data, err := os.ReadFile(filepath.Join(folder, manifestName))
+	if err != nil {
+		return PastStream{}, err
+	}
+	var manifest PastStream
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return PastStream{}, err
+	}
+	manifest.Folder = filepath.Base(folder)
+	return manifest, nil
+}
+
+// withURLs turns the manifest's relative paths into links clients can load
+func withURLs(s PastStream) PastStream {
+	base := "/" + path.Join(strings.TrimPrefix(pastStreamsDir, "web/"), s.Folder)
+	if s.MP4 != "" {
+		s.MP4 = utils.MediaURL(base + "/" + s.MP4)
+	}
+	if s.SegmentsDeleted && s.MP4 != "" {
+		// Only the MP4 is left to play
+		s.RecordingURL = s.MP4
+	} else if s.RecordingURL == "" {
+		// Renditions are played through the master playlist so players can switch between them
+		playlist := s.Playlist
+		if playlist == "" || strings.Contains(playlist, "/") {
+			playlist = masterPlaylistName
+		}
+		s.RecordingURL = utils.MediaURL(base + "/" + playlist)
+	}
+	if s.Poster != "" {
+		s.Poster = utils.MediaURL(base + "/" + s.Poster)
+	}
+	return s
+}
+
+// recordingPlaylist finds the media playlist that best represents a recording: the first
+// video rendition of the master playlist, or the single playlist of older recordings
+func recordingPlaylist(folder string) (string, []playlistEntry, error) {
+	candidates := []string{}
+
+	if data, err := os.ReadFile(filepath.Join(folder, masterPlaylistName)); err == nil {
+		var fallback string
+		isVideo := false
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			switch {
+			case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+				isVideo = strings.Contains(line, "RESOLUTION=")
+			case line != "" && !strings.HasPrefix(line, "#"):
+				if isVideo {
+					candidates = append(candidates, filepath.Join(folder, filepath.FromSlash(line)))
+				} else if fallback == "" {
+					fallback = filepath.Join(folder, filepath.FromSlash(line))
+				}
+			}
+		}
+		if fallback != "" {
+			candidates = append(candidates, fallback)
+		}
+	}
+	candidates = append(candidates, filepath.Join(folder, variantPlaylistName))
+
+	for _, candidate := range candidates {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		if entries := parseMediaPlaylist(data); len(entries) > 0 {
+			return candidate, entries, nil
+		}
+	}
+	return "", nil, os.ErrNotExist
+}
+
+// generatePoster grabs a frame a tenth of the way into the recording
+func generatePoster(folder, playlist string, entries []playlistEntry) error {
+	segment := entries[len(entries)/10].uri
+	segmentPath := filepath.Join(filepath.Dir(playlist), filepath.FromSlash(segment))
+	return grabFrame(segmentPath, filepath.Join(folder, posterName))
+}
+
+// grabFrame writes the first video frame of input as a 640px wide JPEG
+func grabFrame(input, output string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), posterTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y", "-loglevel", "error",
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", "scale=640:-2",
+		output,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func folderSize(folder string) int64 {
+	var size int64
+	filepath.WalkDir(folder, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func mustRel(base, target string) string {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return filepath.Base(target)
+	}
+	return rel
+}
+
+func hasStreamTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.ToLower(t) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// pastStreamTime orders recordings by when they ended, falling back to the start
+func pastStreamTime(s PastStream) int64 {
+	if ends, err := strconv.ParseInt(s.Ends, 10, 64); err == nil {
+		return ends
+	}
+	starts, _ := strconv.ParseInt(s.Starts, 10, 64)
+	return starts
+}
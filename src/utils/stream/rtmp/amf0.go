@@ -0,0 +1,205 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AMF0 type markers used by RTMP command and data messages
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+)
+
+// amfObject is a decoded AMF0 object or ECMA array
+type amfObject map[string]interface{}
+
+// decodeAMF0 decodes every value in an AMF0 payload
+func decodeAMF0(data []byte) ([]interface{}, error) {
+	r := bytes.NewReader(data)
+	var values []interface{}
+	for r.Len() > 0 {
+		v, err := readAMF0Value(r)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func readAMF0Value(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amf0Number:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amf0Boolean:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		return b != 0, nil
+	case amf0String:
+		return readAMF0String(r)
+	case amf0LongString:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf), nil
+	case amf0Object:
+		return readAMF0Properties(r)
+	case amf0ECMAArray:
+		// The associative count is advisory, the list is still end-marker terminated
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return readAMF0Properties(r)
+	case amf0StrictArray:
+		var n uint32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		arr := make([]interface{}, 0, n)
+		for i := uint32(0); i < n; i++ {
+			v, err := readAMF0Value(r)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case amf0Date:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		// Skip the timezone, it is always zero in practice
+		if _, err := r.Seek(2, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amf0Null, amf0Undefined:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported AMF0 marker 0x%02x", marker)
+	}
+}
+
+func readAMF0String(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func readAMF0Properties(r *bytes.Reader) (amfObject, error) {
+	obj := make(amfObject)
+	for {
+		key, err := readAMF0String(r)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			end, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if end != amf0ObjectEnd {
+				return nil, errors.New("AMF0 object missing end marker")
+			}
+			return obj, nil
+		}
+		v, err := readAMF0Value(r)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+	}
+}
+
+// encodeAMF0 encodes the given values back to back
+func encodeAMF0(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		writeAMF0Value(&buf, v)
+	}
+	return buf.Bytes()
+}
+
+func writeAMF0Value(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(amf0Null)
+	case bool:
+		buf.WriteByte(amf0Boolean)
+		if val {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case int:
+		writeAMF0Value(buf, float64(val))
+	case float64:
+		buf.WriteByte(amf0Number)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(val))
+	case string:
+		if len(val) > math.MaxUint16 {
+			buf.WriteByte(amf0LongString)
+			binary.Write(buf, binary.BigEndian, uint32(len(val)))
+		} else {
+			buf.WriteByte(amf0String)
+			binary.Write(buf, binary.BigEndian, uint16(len(val)))
+		}
+		buf.WriteString(val)
+	case amfObject:
+		buf.WriteByte(amf0Object)
+
+		// Sort keys so responses are byte-for-byte stable
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			binary.Write(buf, binary.BigEndian, uint16(len(k)))
+			buf.WriteString(k)
+			writeAMF0Value(buf, val[k])
+		}
+		buf.Write([]byte{0, 0, amf0ObjectEnd})
+	default:
+		buf.WriteByte(amf0Undefined)
+	}
+}
@@ -0,0 +1,269 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// RTMP message type IDs
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAcknowledgement  = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+const (
+	defaultChunkSize  = 128
+	maxMessageLength  = 16 << 20 // Anything bigger is a broken or hostile client
+	extendedTimestamp = 0xFFFFFF
+)
+
+// message is a fully reassembled RTMP message
+type message struct {
+	typeID    uint8
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// chunkState tracks the last header seen on a chunk stream ID
+type chunkState struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	extended  bool
+	buf       []byte
+}
+
+// chunkReader reassembles messages from the interleaved chunk stream
+type chunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkState
+	bytesRead uint64
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{
+		r:         bufio.NewReaderSize(r, 64*1024),
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkState),
+	}
+}
+
+func (cr *chunkReader) readFull(buf []byte) error {
+	n, err := io.ReadFull(cr.r, buf)
+	cr.bytesRead += uint64(n)
+	return err
+}
+
+func (cr *chunkReader) readUint24() (uint32, error) {
+	var b [3]byte
+	if err := cr.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), nil
+}
+
+func (cr *chunkReader) readUint32() (uint32, error) {
+	var b [4]byte
+	if err := cr.readFull(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// readMessage reads chunks until one message is complete
+func (cr *chunkReader) readMessage() (*message, error) {
+	for {
+		var first [1]byte
+		if err := cr.readFull(first[:]); err != nil {
+			return nil, err
+		}
+
+		format := first[0] >> 6
+		csid := uint32(first[0] & 0x3F)
+		switch csid {
+		case 0:
+			var b [1]byte
+			if err := cr.readFull(b[:]); err != nil {
+				return nil, err
+			}
+			csid = uint32(b[0]) + 64
+		case 1:
+			var b [2]byte
+			if err := cr.readFull(b[:]); err != nil {
+				return nil, err
+			}
+			csid = uint32(b[1])*256 + uint32(b[0]) + 64
+		}
+
+		st, ok := cr.streams[csid]
+		if !ok {
+			if format != 0 {
+				return nil, fmt.Errorf("chunk stream %d started without a type 0 header", csid)
+			}
+			st = &chunkState{}
+			cr.streams[csid] = st
+		}
+
+		var ts uint32
+		var err error
+		if format <= 2 {
+			if ts, err = cr.readUint24(); err != nil {
+				return nil, err
+			}
+		}
+		if format <= 1 {
+			if st.length, err = cr.readUint24(); err != nil {
+				return nil, err
+			}
+			var b [1]byte
+			if err := cr.readFull(b[:]); err != nil {
+				return nil, err
+			}
+			st.typeID = b[0]
+		}
+		if format == 0 {
+			var b [4]byte
+			if err := cr.readFull(b[:]); err != nil {
+				return nil, err
+			}
+			// The message stream ID is the one little-endian field in RTMP
+			st.streamID = binary.LittleEndian.Uint32(b[:])
+		}
+
+		if format <= 2 {
+			st.extended = ts == extendedTimestamp
+		}
+		if st.extended {
+			if ts, err = cr.readUint32(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Timestamps only advance at the first chunk of each message
+		if len(st.buf) == 0 {
+			switch format {
+			case 0:
+				st.timestamp = ts
+				st.delta = ts
+			case 1, 2:
+				st.delta = ts
+				st.timestamp += ts
+			case 3:
+				st.timestamp += st.delta
+			}
+		}
+
+		if st.length > maxMessageLength {
+			return nil, fmt.Errorf("message length %d exceeds limit", st.length)
+		}
+
+		remaining := st.length - uint32(len(st.buf))
+		n := remaining
+		if n > cr.chunkSize {
+			n = cr.chunkSize
+		}
+
+		chunk := make([]byte, n)
+		if err := cr.readFull(chunk); err != nil {
+			return nil, err
+		}
+		st.buf = append(st.buf, chunk...)
+
+		if uint32(len(st.buf)) == st.length {
+			msg := &message{
+				typeID:    st.typeID,
+				streamID:  st.streamID,
+				timestamp: st.timestamp,
+				payload:   st.buf,
+			}
+			st.buf = nil
+			return msg, nil
+		}
+	}
+}
+
+// abort discards a partially received message on the given chunk stream
+func (cr *chunkReader) abort(csid uint32) {
+	if st, ok := cr.streams[csid]; ok {
+		st.buf = nil
+	}
+}
+
+// chunkWriter splits outgoing messages into chunks
+type chunkWriter struct {
+	w         *bufio.Writer
+	chunkSize uint32
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:         bufio.NewWriter(w),
+		chunkSize: defaultChunkSize,
+	}
+}
+
+// writeMessage sends one message with a type 0 header followed by type 3 continuations
+func (cw *chunkWriter) writeMessage(csid uint32, msg *message) error {
+	header := make([]byte, 0, 16)
+	header = append(header, byte(csid&0x3F))
+
+	ts := msg.timestamp
+	if ts >= extendedTimestamp {
+		ts = extendedTimestamp
+	}
+	length := uint32(len(msg.payload))
+	header = append(header,
+		byte(ts>>16), byte(ts>>8), byte(ts),
+		byte(length>>16), byte(length>>8), byte(length),
+		msg.typeID,
+	)
+	header = binary.LittleEndian.AppendUint32(header, msg.streamID)
+	if ts == extendedTimestamp {
+		header = binary.BigEndian.AppendUint32(header, msg.timestamp)
+	}
+
+	if _, err := cw.w.Write(header); err != nil {
+		return err
+	}
+
+	payload := msg.payload
+	for len(payload) > 0 {
+		n := uint32(len(payload))
+		if n > cw.chunkSize {
+			n = cw.chunkSize
+		}
+		if _, err := cw.w.Write(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+
+		if len(payload) > 0 {
+			if err := cw.w.WriteByte(0xC0 | byte(csid&0x3F)); err != nil {
+				return err
+			}
+			if ts == extendedTimestamp {
+				if err := binary.Write(cw.w, binary.BigEndian, msg.timestamp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return cw.w.Flush()
+}
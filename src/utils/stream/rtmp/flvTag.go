@@ -0,0 +1,61 @@
+package rtmp
+
+import "encoding/binary"
+
+// FLV tag types match the RTMP message type IDs they carry
+const (
+	TagAudio  = 8
+	TagVideo  = 9
+	TagScript = 18
+)
+
+// FLVHeader is the file header for a stream with both audio and video,
+// followed by the zero PreviousTagSize0 field
+var FLVHeader = []byte{'F', 'L', 'V', 0x01, 0x05, 0, 0, 0, 9, 0, 0, 0, 0}
+
+// EncodeFLVTag serializes a single FLV tag including its trailing PreviousTagSize
+func EncodeFLVTag(tagType uint8, timestamp uint32, data []byte) []byte {
+	size := uint32(len(data))
+	tag := make([]byte, 0, 11+len(data)+4)
+	tag = append(tag,
+		tagType,
+		byte(size>>16), byte(size>>8), byte(size),
+		byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24),
+		0, 0, 0,
+	)
+	tag = append(tag, data...)
+	tag = binary.BigEndian.AppendUint32(tag, 11+size)
+	return tag
+}
+
+// IsSequenceHeader reports whether an audio or video tag carries decoder
+// configuration (AVCDecoderConfigurationRecord or AudioSpecificConfig)
+func IsSequenceHeader(tagType uint8, data []byte) bool {
+	switch tagType {
+	case TagVideo:
+		// AVC codec id 7 with AVCPacketType 0
+		return len(data) >= 2 && data[0]&0x0F == 7 && data[1] == 0
+	case TagAudio:
+		// AAC sound format 10 with AACPacketType 0
+		return len(data) >= 2 && data[0]>>4 == 10 && data[1] == 0
+	}
+	return false
+}
+
+// MetadataHasAudio reads an onMetaData script tag and reports whether it announces an
+// audio track. ok is false when the tag isn't metadata the encoder filled in.
+func MetadataHasAudio(data []byte) (hasAudio, ok bool) {
+	values, _ := decodeAMF0(data)
+	if len(values) < 2 {
+		return false, false
+	}
+	if name, _ := values[0].(string); name != "onMetaData" {
+		return false, false
+	}
+	metadata, isObject := values[1].(amfObject)
+	if !isObject || len(metadata) == 0 {
+		return false, false
+	}
+	_, hasAudio = metadata["audiocodecid"]
+	return hasAudio, true
+}
@@ -0,0 +1,51 @@
+package rtmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	rtmpVersion   = 3
+	handshakeSize = 1536
+)
+
+// serverHandshake performs the plain RTMP handshake from the server side.
+// S1 carries a zero version field so digest-aware clients (FFmpeg, OBS)
+// fall back to the simple scheme instead of validating an HMAC.
+func serverHandshake(rw io.ReadWriter) error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(rw, c0c1); err != nil {
+		return fmt.Errorf("failed to read C0/C1: %w", err)
+	}
+	if c0c1[0] != rtmpVersion {
+		return fmt.Errorf("unsupported RTMP version %d", c0c1[0])
+	}
+	c1 := c0c1[1:]
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = rtmpVersion
+
+	s1 := s0s1s2[1 : 1+handshakeSize]
+	binary.BigEndian.PutUint32(s1[0:4], uint32(time.Now().Unix()))
+	if _, err := rand.Read(s1[8:]); err != nil {
+		return fmt.Errorf("failed to generate S1: %w", err)
+	}
+
+	// S2 echoes C1 back to the client
+	copy(s0s1s2[1+handshakeSize:], c1)
+
+	if _, err := rw.Write(s0s1s2); err != nil {
+		return fmt.Errorf("failed to write S0/S1/S2: %w", err)
+	}
+
+	c2 := make([]byte, handshakeSize)
+	if _, err := io.ReadFull(rw, c2); err != nil {
+		return fmt.Errorf("failed to read C2: %w", err)
+	}
+
+	return nil
+}
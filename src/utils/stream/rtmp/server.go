@@ -0,0 +1,382 @@
+package rtmp
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler receives the lifecycle and media of an authenticated publisher
+type Handler interface {
+	// PublishStart is called once a publish request passes authentication.
+	// Returning an error rejects the publisher.
+	PublishStart(app, streamKey string) error
+
+	// WriteTag receives every audio, video and script tag in arrival order
+	WriteTag(tagType uint8, timestamp uint32, data []byte)
+
+	// PublishStop is called when the publisher unpublishes or disconnects
+	PublishStop(app, streamKey string)
+}
+
+// Server is a minimal RTMP ingest server that accepts a single publisher
+type Server struct {
+	Addr      string  // Listen address, e.g. ":1935"
+	App       string  // Application name to accept, empty accepts any
+	StreamKey string  // Required stream key
+	Handler   Handler // Receives publish events and media
+
+	mu         sync.Mutex
+	listener   net.Listener
+	publishing bool
+}
+
+const (
+	serverChunkSize  = 4096
+	serverWindowSize = 2500000
+	handshakeTimeout = 10 * time.Second
+	idleTimeout      = 30 * time.Second
+	publishStreamID  = 1
+)
+
+// ListenAndServe accepts RTMP connections until the listener is closed
+func (s *Server) ListenAndServe() error {
+	if s.Handler == nil {
+		return errors.New("rtmp: no handler configured")
+	}
+	if s.StreamKey == "" {
+		return errors.New("rtmp: a stream key is required")
+	}
+
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("rtmp: failed to listen on %s: %w", s.Addr, err)
+	}
+
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+
+	log.Printf("RTMP ingest listening on %s", s.Addr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("RTMP accept error: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		sess := &session{
+			server: s,
+			conn:   conn,
+			reader: newChunkReader(conn),
+			writer: newChunkWriter(conn),
+		}
+		go sess.serve()
+	}
+}
+
+// Close stops accepting new connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// claimPublisher reserves the single publishing slot
+func (s *Server) claimPublisher() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.publishing {
+		return false
+	}
+	s.publishing = true
+	return true
+}
+
+func (s *Server) releasePublisher() {
+	s.mu.Lock()
+	s.publishing = false
+	s.mu.Unlock()
+}
+
+// session is one client connection
+type session struct {
+	server *Server
+	conn   net.Conn
+	reader *chunkReader
+	writer *chunkWriter
+
+	app        string
+	streamKey  string
+	publishing bool
+
+	windowAckSize uint32
+	lastAck       uint64
+}
+
+func (s *session) serve() {
+	remote := s.conn.RemoteAddr().String()
+	defer s.conn.Close()
+	defer s.stopPublishing()
+
+	s.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := serverHandshake(s.conn); err != nil {
+		log.Printf("RTMP handshake with %s failed: %v", remote, err)
+		return
+	}
+	s.conn.SetDeadline(time.Time{})
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		msg, err := s.reader.readMessage()
+		if err != nil {
+			if s.publishing {
+				log.Printf("RTMP publisher %s disconnected: %v", remote, err)
+			}
+			return
+		}
+
+		if err := s.handleMessage(msg); err != nil {
+			log.Printf("RTMP session %s closed: %v", remote, err)
+			return
+		}
+
+		if err := s.sendAckIfDue(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) handleMessage(msg *message) error {
+	switch msg.typeID {
+	case msgSetChunkSize:
+		if len(msg.payload) < 4 {
+			return errors.New("short set chunk size message")
+		}
+		size := binary.BigEndian.Uint32(msg.payload) & 0x7FFFFFFF
+		if size == 0 || size > 0xFFFFFF {
+			return fmt.Errorf("invalid chunk size %d", size)
+		}
+		s.reader.chunkSize = size
+	case msgAbort:
+		if len(msg.payload) >= 4 {
+			s.reader.abort(binary.BigEndian.Uint32(msg.payload))
+		}
+	case msgWindowAckSize:
+		if len(msg.payload) >= 4 {
+			s.windowAckSize = binary.BigEndian.Uint32(msg.payload)
+		}
+	case msgAcknowledgement, msgUserControl, msgSetPeerBandwidth:
+		// Nothing to do for an ingest-only server
+	case msgCommandAMF0:
+		return s.handleCommand(msg.payload)
+	case msgCommandAMF3:
+		// AMF3 commands are AMF0 bodies behind a format byte
+		if len(msg.payload) > 0 {
+			return s.handleCommand(msg.payload[1:])
+		}
+	case msgDataAMF0:
+		s.handleData(msg)
+	case msgDataAMF3:
+		if len(msg.payload) > 0 {
+			msg.payload = msg.payload[1:]
+			s.handleData(msg)
+		}
+	case msgAudio, msgVideo:
+		if s.publishing && len(msg.payload) > 0 {
+			s.server.Handler.WriteTag(msg.typeID, msg.timestamp, msg.payload)
+		}
+	}
+	return nil
+}
+
+// handleData forwards onMetaData to the handler as an FLV script tag
+func (s *session) handleData(msg *message) {
+	if !s.publishing {
+		return
+	}
+
+	payload := msg.payload
+	values, err := decodeAMF0(payload)
+	if err != nil || len(values) == 0 {
+		return
+	}
+
+	// Encoders wrap metadata in @setDataFrame, which FLV files do not carry
+	if name, _ := values[0].(string); name == "@setDataFrame" {
+		payload = payload[3+len(name):]
+	}
+
+	s.server.Handler.WriteTag(TagScript, msg.timestamp, payload)
+}
+
+func (s *session) handleCommand(payload []byte) error {
+	values, err := decodeAMF0(payload)
+	if err != nil && len(values) < 2 {
+		return fmt.Errorf("malformed command: %w", err)
+	}
+	if len(values) < 2 {
+		return nil
+	}
+
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+
+	switch name {
+	case "connect":
+		return s.handleConnect(txn, values)
+	case "releaseStream", "FCPublish":
+		return s.sendCommand(0, "_result", txn, nil)
+	case "createStream":
+		return s.sendCommand(0, "_result", txn, nil, float64(publishStreamID))
+	case "publish":
+		return s.handlePublish(values)
+	case "FCUnpublish", "deleteStream", "closeStream":
+		s.stopPublishing()
+	}
+	return nil
+}
+
+func (s *session) handleConnect(txn float64, values []interface{}) error {
+	if len(values) > 2 {
+		if obj, ok := values[2].(amfObject); ok {
+			app, _ := obj["app"].(string)
+			s.app = strings.Trim(app, "/")
+		}
+	}
+
+	if s.server.App != "" && s.app != s.server.App {
+		s.sendCommand(0, "_error", txn, nil, amfObject{
+			"level":       "error",
+			"code":        "NetConnection.Connect.Rejected",
+			"description": "Unknown application",
+		})
+		return fmt.Errorf("rejected unknown application %q", s.app)
+	}
+
+	if err := s.sendControl(msgWindowAckSize, binary.BigEndian.AppendUint32(nil, serverWindowSize)); err != nil {
+		return err
+	}
+	// Limit type 2 (dynamic)
+	if err := s.sendControl(msgSetPeerBandwidth, append(binary.BigEndian.AppendUint32(nil, serverWindowSize), 2)); err != nil {
+		return err
+	}
+	if err := s.sendControl(msgSetChunkSize, binary.BigEndian.AppendUint32(nil, serverChunkSize)); err != nil {
+		return err
+	}
+	s.writer.chunkSize = serverChunkSize
+
+	return s.sendCommand(0, "_result", txn,
+		amfObject{
+			"fmsVer":       "FMS/3,0,1,123",
+			"capabilities": float64(31),
+		},
+		amfObject{
+			"level":          "status",
+			"code":           "NetConnection.Connect.Success",
+			"description":    "Connection succeeded.",
+			"objectEncoding": float64(0),
+		},
+	)
+}
+
+func (s *session) handlePublish(values []interface{}) error {
+	var key string
+	if len(values) > 3 {
+		key, _ = values[3].(string)
+	}
+
+	// Some encoders append query parameters to the stream key
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		key = key[:i]
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.server.StreamKey)) != 1 {
+		s.sendStatus("error", "NetStream.Publish.BadName", "Invalid stream key")
+		return errors.New("publish rejected: invalid stream key")
+	}
+
+	if !s.server.claimPublisher() {
+		s.sendStatus("error", "NetStream.Publish.BadName", "Stream is already being published")
+		return errors.New("publish rejected: another publisher is active")
+	}
+
+	if err := s.server.Handler.PublishStart(s.app, key); err != nil {
+		s.server.releasePublisher()
+		s.sendStatus("error", "NetStream.Publish.Failed", err.Error())
+		return fmt.Errorf("publish rejected by handler: %w", err)
+	}
+
+	s.streamKey = key
+	s.publishing = true
+	log.Printf("RTMP publish started from %s (app %q)", s.conn.RemoteAddr(), s.app)
+
+	// User control StreamBegin for the publish stream
+	streamBegin := []byte{0, 0}
+	streamBegin = binary.BigEndian.AppendUint32(streamBegin, publishStreamID)
+	if err := s.sendControl(msgUserControl, streamBegin); err != nil {
+		return err
+	}
+
+	return s.sendStatus("status", "NetStream.Publish.Start", "Publishing stream.")
+}
+
+// stopPublishing notifies the handler once when publishing ends
+func (s *session) stopPublishing() {
+	if !s.publishing {
+		return
+	}
+	s.publishing = false
+	s.server.Handler.PublishStop(s.app, s.streamKey)
+	s.server.releasePublisher()
+	log.Printf("RTMP publish stopped from %s", s.conn.RemoteAddr())
+}
+
+func (s *session) sendAckIfDue() error {
+	if s.windowAckSize == 0 {
+		return nil
+	}
+	if s.reader.bytesRead-s.lastAck < uint64(s.windowAckSize) {
+		return nil
+	}
+	s.lastAck = s.reader.bytesRead
+	return s.sendControl(msgAcknowledgement, binary.BigEndian.AppendUint32(nil, uint32(s.reader.bytesRead)))
+}
+
+func (s *session) sendControl(typeID uint8, payload []byte) error {
+	return s.writer.writeMessage(2, &message{typeID: typeID, payload: payload})
+}
+
+func (s *session) sendCommand(streamID uint32, values ...interface{}) error {
+	return s.writer.writeMessage(3, &message{
+		typeID:   msgCommandAMF0,
+		streamID: streamID,
+		payload:  encodeAMF0(values...),
+	})
+}
+
+func (s *session) sendStatus(level, code, description string) error {
+	return s.writer.writeMessage(5, &message{
+		typeID:   msgCommandAMF0,
+		streamID: publishStreamID,
+		payload: encodeAMF0("onStatus", float64(0), nil, amfObject{
+			"level":       level,
+			"code":        code,
+			"description": description,
+		}),
+	})
+}
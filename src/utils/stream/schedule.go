@@ -0,0 +1,245 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"goFrame/src/utils/stream/nostr"
+)
+
+const scheduleDir = "data/schedule"
+
+var (
+	scheduleMutex sync.Mutex
+	validDtag     = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	// boundToSchedule is set while the current stream uses a scheduled event's metadata
+	boundToSchedule bool
+)
+
+// ScheduledStream is a planned broadcast announced ahead of time
+type ScheduledStream struct {
+	Dtag    string   `json:"dtag"`
+	Title   string   `json:"title"`
+	Summary string   `json:"summary"`
+	Image   string   `json:"image"`
+	Tags    []string `json:"tags"`
+	Starts  string   `json:"starts"` // Unix timestamp
+	Status  string   `json:"status"`
+}
+
+// liveEvent converts the scheduled stream into its planned Nostr event
+func (s ScheduledStream) liveEvent() nostr.LiveEvent {
+	return nostr.LiveEvent{
+		Dtag:    s.Dtag,
+		Title:   s.Title,
+		Summary: s.Summary,
+		Image:   s.Image,
+		Tags:    s.Tags,
+		Starts:  s.Starts,
+	}
+}
+
+// ListScheduledStreams returns upcoming streams, soonest first. Streams whose start
+// time has passed are kept until the bind window closes in case the host is late.
+func ListScheduledStreams() ([]ScheduledStream, error) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	all, err := readSchedule()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-scheduleWindow()).Unix()
+	upcoming := []ScheduledStream{}
+	for _, s := range all {
+		if startsUnix(s) >= cutoff {
+			upcoming = append(upcoming, s)
+		}
+	}
+	return upcoming, nil
+}
+
+// CreateScheduledStream stores a new scheduled stream and announces it as planned
+func CreateScheduledStream(s ScheduledStream) (ScheduledStream, error) {
+	if err := validateScheduledStream(s); err != nil {
+		return ScheduledStream{}, err
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	for {
+		s.Dtag = generateDtag()
+		if _, err := os.Stat(schedulePath(s.Dtag)); os.IsNotExist(err) {
+			break
+		}
+	}
+	s.Status = "planned"
+
+	if err := writeScheduledStream(s); err != nil {
+		return ScheduledStream{}, err
+	}
+	go nostr.BroadcastNostrPlannedEvent(s.liveEvent())
+	return s, nil
+}
+
+// UpdateScheduledStream replaces the details of a scheduled stream and re-announces it
+func UpdateScheduledStream(dtag string, s ScheduledStream) (ScheduledStream, error) {
+	if err := validateScheduledStream(s); err != nil {
+		return ScheduledStream{}, err
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	if !scheduledStreamExists(dtag) {
+		return ScheduledStream{}, os.ErrNotExist
+	}
+	s.Dtag = dtag
+	s.Status = "planned"
+
+	if err := writeScheduledStream(s); err != nil {
+		return ScheduledStream{}, err
+	}
+	go nostr.BroadcastNostrPlannedEvent(s.liveEvent())
+	return s, nil
+}
+
+// DeleteScheduledStream cancels a scheduled stream and asks relays to delete its event
+func DeleteScheduledStream(dtag string) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	if !scheduledStreamExists(dtag) {
+		return os.ErrNotExist
+	}
+	if err := os.Remove(schedulePath(dtag)); err != nil {
+		return err
+	}
+	go nostr.BroadcastNostrDeleteEvent(dtag, "Scheduled stream cancelled")
+	return nil
+}
+
+// claimScheduledStream returns the scheduled stream closest to now within the bind window.
+// It stays scheduled until releaseScheduledStream, so a stream that fails to start keeps it.
+func claimScheduledStream(now time.Time) *ScheduledStream {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	all, err := readSchedule()
+	if err != nil {
+		log.Printf("Failed to read scheduled streams: %v", err)
+		return nil
+	}
+
+	window := int64(scheduleWindow().Seconds())
+	var best *ScheduledStream
+	var bestDistance int64
+	for i := range all {
+		distance := startsUnix(all[i]) - now.Unix()
+		if distance < 0 {
+			distance = -distance
+		}
+		if distance <= window && (best == nil || distance < bestDistance) {
+			best = &all[i]
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// releaseScheduledStream removes a scheduled stream once a broadcast has gone live as it
+func releaseScheduledStream(dtag string) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	if err := os.Remove(schedulePath(dtag)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove claimed scheduled stream: %v", err)
+	}
+}
+
+func validateScheduledStream(s ScheduledStream) error {
+	if s.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	starts, err := strconv.ParseInt(s.Starts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("starts must be a unix timestamp")
+	}
+	if starts < time.Now().Unix() {
+		return fmt.Errorf("starts must be in the future")
+	}
+	return nil
+}
+
+func readSchedule() ([]ScheduledStream, error) {
+	files, err := filepath.Glob(filepath.Join(scheduleDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []ScheduledStream
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var s ScheduledStream
+		if err := json.Unmarshal(data, &s); err != nil {
+			log.Printf("Skipping invalid scheduled stream %s: %v", file, err)
+			continue
+		}
+		streams = append(streams, s)
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return startsUnix(streams[i]) < startsUnix(streams[j])
+	})
+	return streams, nil
+}
+
+func writeScheduledStream(s ScheduledStream) error {
+	if err := os.MkdirAll(scheduleDir, os.ModePerm); err != nil {
+		return err
+	}
+	if s.Tags == nil {
+		s.Tags = []string{}
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(schedulePath(s.Dtag), data, 0644)
+}
+
+func scheduledStreamExists(dtag string) bool {
+	if !validDtag.MatchString(dtag) {
+		return false
+	}
+	_, err := os.Stat(schedulePath(dtag))
+	return err == nil
+}
+
+func schedulePath(dtag string) string {
+	return filepath.Join(scheduleDir, dtag+".json")
+}
+
+func startsUnix(s ScheduledStream) int64 {
+	starts, _ := strconv.ParseInt(s.Starts, 10, 64)
+	return starts
+}
+
+// scheduleWindow is how far from its start time a stream can go live and still claim a scheduled event
+func scheduleWindow() time.Duration {
+	return time.Duration(streamConfig.Lifecycle.ScheduleWindow) * time.Second
+}
@@ -1,45 +1,55 @@
 package stream
 
 import (
+	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"time"
 )
 
-func startHLSStream() {
+// audioProbeTimeout is how long a new publisher gets to show whether it sends audio
+const audioProbeTimeout = 3 * time.Second
+
+// startHLSStream prepares web/live and starts the encoder
+func startHLSStream() error {
 	log.Println("Starting HLS stream...")
 
-	outputDir := "web/live"
-	outputFile := filepath.Join(outputDir, "output.m3u8")
+	outputDir := liveDir
 	metadataFile := filepath.Join(outputDir, "metadata.json")
 
 	// Ensure the directory exists
 	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
-		log.Fatalf("Failed to create output directory: %v", err)
+		return fmt.Errorf("create output directory: %w", err)
 	}
 
 	// Save metadata as JSON
-	if err := saveMetadata(metadataFile); err != nil {
-		log.Fatalf("Failed to save metadata: %v", err)
+	metadataMutex.Lock()
+	err := saveMetadata(metadataFile)
+	metadataMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("save metadata: %w", err)
+	}
+
+	// Video-only sources get a ladder without audio, FFmpeg can't map a missing stream
+	withAudio := ingest.hasAudio(audioProbeTimeout)
+	if !withAudio {
+		log.Println("Publisher sends no audio, encoding video only")
 	}
+	setSourceAudio(withAudio)
+
+	// Players pick a rendition from the master playlist
+	if err := writeMasterPlaylist(outputDir, variantPlaylistName); err != nil {
+		return fmt.Errorf("write master playlist: %w", err)
+	}
+
+	// Read FLV from the RTMP ingest on stdin, the supervisor restarts FFmpeg if it crashes
+	hlsTranscoder.start(buildHLSArgs(outputDir))
 
-	// Start FFmpeg without metadata embedding
-	ffmpegCmd := exec.Command("ffmpeg",
-		"-i", streamConfig.RTMPStreamURL,
-		"-c:v", "libx264",
-		"-crf", "18",
-		"-preset", "veryfast",
-		"-c:a", "aac",
-		"-b:a", "160k",
-		"-f", "hls",
-		"-hls_time", "10",
-		"-hls_list_size", "0",
-		outputFile,
-	)
-
-	if err := ffmpegCmd.Start(); err != nil {
-		log.Fatalf("Failed to start FFmpeg: %v", err)
+	// Keep a short live playlist in front of the full recording
+	if rollingWindowEnabled() {
+		startLiveWindows(outputDir)
 	}
 	log.Println("HLS stream started.")
+	return nil
 }
@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"goFrame/src/utils/stream/nostr"
+)
+
+const liveMetadataFile = "web/live/metadata.json"
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// registerStateHooks wires the built-in subscribers in the order they must run
+func registerStateHooks() {
+	OnStateChange(broadcastStateChange)
+	OnStateChange(archiveOnStateChange)
+	OnStateChange(chatOnStateChange)
+	OnStateChange(viewersOnStateChange)
+	OnStateChange(notifyWebhooks)
+}
+
+// broadcastStateChange publishes the live event when a stream starts and the end event once it is over.
+// Reconnects inside the grace window keep the existing event.
+func broadcastStateChange(t StateTransition) {
+	switch {
+	case t.To == StateLive && !t.Resumed:
+		nostr.BroadcastNostrStartEvent(currentLiveEvent())
+	case t.To == StateArchived:
+		nostr.BroadcastNostrEndEvent(currentLiveEvent())
+	}
+}
+
+// archiveOnStateChange moves the recording into past streams
+func archiveOnStateChange(t StateTransition) {
+	if t.To != StateArchived {
+		return
+	}
+	joinRecordingParts(liveDir)
+	archiveLiveRecording(archiveDir(t.Dtag, lifecycle.snapshot().StartedAt))
+}
+
+// notifyWebhooks posts every transition to the configured webhook URLs
+func notifyWebhooks(t StateTransition) {
+	if len(streamConfig.Lifecycle.Webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(t)
+	if err != nil {
+		log.Printf("Failed to encode webhook payload: %v", err)
+		return
+	}
+
+	for _, url := range streamConfig.Lifecycle.Webhooks {
+		go postWebhook(url, body)
+	}
+}
+
+func postWebhook(url string, body []byte) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Invalid webhook URL %s: %v", url, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Receivers can verify the payload with the shared secret
+	if secret := streamConfig.Lifecycle.WebhookSecret; secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Stream-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("Webhook %s failed: %v", url, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Webhook %s returned %s", url, resp.Status)
+	}
+}
@@ -0,0 +1,210 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// StreamState is a step in the lifecycle of a broadcast
+type StreamState string
+
+const (
+	StateOffline  StreamState = "offline"  // Nobody is publishing
+	StateStarting StreamState = "starting" // A publisher connected, encoding is starting
+	StateLive     StreamState = "live"     // Segments are being published
+	StateEnding   StreamState = "ending"   // The publisher left, waiting out the reconnect grace window
+	StateArchived StreamState = "archived" // The recording has been moved to past streams
+)
+
+// allowedTransitions lists the states each state may move to
+var allowedTransitions = map[StreamState][]StreamState{
+	StateOffline:  {StateStarting},
+	StateStarting: {StateLive, StateEnding},
+	StateLive:     {StateEnding},
+	StateEnding:   {StateStarting, StateArchived},
+	StateArchived: {StateOffline},
+}
+
+const stateHistorySize = 100
+
+// StateTransition records one move between states
+type StateTransition struct {
+	From    StreamState `json:"from"`
+	To      StreamState `json:"to"`
+	Dtag    string      `json:"dtag,omitempty"`
+	Reason  string      `json:"reason,omitempty"`
+	Resumed bool        `json:"resumed,omitempty"` // Set when a reconnect continues the previous event
+	At      time.Time   `json:"at"`
+}
+
+// StateSnapshot is the current state and recent history exposed through the API
+type StateSnapshot struct {
+	State       StreamState       `json:"state"`
+	Dtag        string            `json:"dtag,omitempty"`
+	Since       time.Time         `json:"since"`
+	StartedAt   time.Time         `json:"started_at,omitempty"`
+	GraceEndsAt *time.Time        `json:"grace_ends_at,omitempty"`
+	History     []StateTransition `json:"history"`
+}
+
+// StateHook is called synchronously, in registration order, after every transition
+type StateHook func(StateTransition)
+
+type stateMachine struct {
+	mu          sync.Mutex
+	state       StreamState
+	dtag        string
+	since       time.Time
+	startedAt   time.Time
+	graceEndsAt time.Time
+	resuming    bool
+	history     []StateTransition
+	hooks       []StateHook
+	subscribers map[chan StateTransition]struct{}
+}
+
+var lifecycle = &stateMachine{
+	state:       StateOffline,
+	since:       time.Now(),
+	subscribers: make(map[chan StateTransition]struct{}),
+}
+
+// OnStateChange registers a hook that runs after every state transition
+func OnStateChange(hook StateHook) {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	lifecycle.hooks = append(lifecycle.hooks, hook)
+}
+
+// SubscribeStateChanges returns a channel of transitions and a function to unsubscribe.
+// Slow subscribers miss transitions rather than block the stream.
+func SubscribeStateChanges() (<-chan StateTransition, func()) {
+	ch := make(chan StateTransition, 16)
+
+	lifecycle.mu.Lock()
+	lifecycle.subscribers[ch] = struct{}{}
+	lifecycle.mu.Unlock()
+
+	return ch, func() {
+		lifecycle.mu.Lock()
+		delete(lifecycle.subscribers, ch)
+		lifecycle.mu.Unlock()
+	}
+}
+
+// GetStreamState returns the current state and its history
+func GetStreamState() StateSnapshot {
+	return lifecycle.snapshot()
+}
+
+func (m *stateMachine) snapshot() StateSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := StateSnapshot{
+		State:     m.state,
+		Dtag:      m.dtag,
+		Since:     m.since,
+		StartedAt: m.startedAt,
+		History:   append([]StateTransition(nil), m.history...),
+	}
+	if m.state == StateEnding && !m.graceEndsAt.IsZero() {
+		graceEndsAt := m.graceEndsAt
+		snapshot.GraceEndsAt = &graceEndsAt
+	}
+	return snapshot
+}
+
+func (m *stateMachine) current() StreamState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// begin starts a new broadcast with the given d tag
+func (m *stateMachine) begin(dtag, reason string) error {
+	m.mu.Lock()
+	if m.state != StateOffline {
+		m.mu.Unlock()
+		return fmt.Errorf("cannot begin a stream while %s", m.state)
+	}
+	m.dtag = dtag
+	m.startedAt = time.Now()
+	m.mu.Unlock()
+
+	return m.transition(StateStarting, reason)
+}
+
+// setGrace records when an ending stream will be archived
+func (m *stateMachine) setGrace(until time.Time) {
+	m.mu.Lock()
+	m.graceEndsAt = until
+	m.mu.Unlock()
+}
+
+// transition moves to a new state and notifies hooks and subscribers
+func (m *stateMachine) transition(to StreamState, reason string) error {
+	m.mu.Lock()
+
+	allowed := false
+	for _, next := range allowedTransitions[m.state] {
+		if next == to {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		from := m.state
+		m.mu.Unlock()
+		return fmt.Errorf("invalid stream state transition %s -> %s", from, to)
+	}
+
+	// A reconnect during the grace window carries through to the live transition
+	if m.state == StateEnding && to == StateStarting {
+		m.resuming = true
+	}
+
+	t := StateTransition{
+		From:    m.state,
+		To:      to,
+		Dtag:    m.dtag,
+		Reason:  reason,
+		Resumed: m.resuming,
+		At:      time.Now(),
+	}
+
+	m.state = to
+	m.since = t.At
+	if to != StateEnding {
+		m.graceEndsAt = time.Time{}
+	}
+	if to == StateLive || to == StateOffline {
+		m.resuming = false
+	}
+	if to == StateOffline {
+		m.dtag = ""
+		m.startedAt = time.Time{}
+	}
+
+	m.history = append(m.history, t)
+	if len(m.history) > stateHistorySize {
+		m.history = m.history[len(m.history)-stateHistorySize:]
+	}
+
+	hooks := append([]StateHook(nil), m.hooks...)
+	for ch := range m.subscribers {
+		select {
+		case ch <- t:
+		default:
+		}
+	}
+	m.mu.Unlock()
+
+	log.Printf("Stream state: %s -> %s (%s)", t.From, t.To, reason)
+	for _, hook := range hooks {
+		hook(t)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	liveDir          = "web/live"
+	maxStorageIssues = 50
+	unarchivedPrefix = ".unarchived-"
+	bytesPerGigabyte = 1 << 30
+)
+
+// StorageIssue is a failed archive or storage operation, kept for the admin API
+type StorageIssue struct {
+	At        time.Time `json:"at"`
+	Operation string    `json:"operation"`
+	Path      string    `json:"path"`
+	Error     string    `json:"error"`
+	DiskFull  bool      `json:"disk_full"`
+}
+
+// StorageStatus summarises the archive and its retention policy
+type StorageStatus struct {
+	Recordings     int            `json:"recordings"`
+	Pinned         int            `json:"pinned"`
+	ArchiveBytes   int64          `json:"archive_bytes"`
+	KeepDays       int            `json:"keep_days"`
+	KeepGB         float64        `json:"keep_gb"`
+	PendingArchive string         `json:"pending_archive,omitempty"`
+	Issues         []StorageIssue `json:"issues"`
+}
+
+// pendingArchive is a recording that could not be moved into past streams yet
+type pendingArchive struct {
+	source string
+	folder string
+}
+
+var storage = struct {
+	sync.Mutex
+	issues  []StorageIssue
+	pending *pendingArchive
+}{}
+
+// reportStorageIssue logs a storage failure and keeps it for the admin API
+func reportStorageIssue(operation, path string, err error) {
+	issue := StorageIssue{
+		At:        time.Now(),
+		Operation: operation,
+		Path:      path,
+		Error:     err.Error(),
+		DiskFull:  errors.Is(err, syscall.ENOSPC),
+	}
+	if issue.DiskFull {
+		log.Printf("DISK FULL: %s %s failed: %v", operation, path, err)
+	} else {
+		log.Printf("Storage error: %s %s failed: %v", operation, path, err)
+	}
+
+	storage.Lock()
+	storage.issues = append(storage.issues, issue)
+	if len(storage.issues) > maxStorageIssues {
+		storage.issues = storage.issues[len(storage.issues)-maxStorageIssues:]
+	}
+	storage.Unlock()
+}
+
+// GetStorageStatus reports archive usage, the retention policy and recent failures
+func GetStorageStatus() StorageStatus {
+	status := StorageStatus{
+		KeepDays: streamConfig.Archive.KeepDays,
+		KeepGB:   streamConfig.Archive.KeepGB,
+	}
+	for _, r := range archivedRecordings() {
+		status.Recordings++
+		status.ArchiveBytes += r.size
+		if r.manifest.Pinned {
+			status.Pinned++
+		}
+	}
+
+	storage.Lock()
+	defer storage.Unlock()
+	if storage.pending != nil {
+		status.PendingArchive = storage.pending.folder
+	}
+	status.Issues = append([]StorageIssue{}, storage.issues...)
+	return status
+}
+
+// SetPastStreamPinned pins a recording so retention never deletes it
+func SetPastStreamPinned(folder string, pinned bool) error {
+	if folder == "" || folder != filepath.Base(folder) || folder[0] == '.' {
+		return os.ErrNotExist
+	}
+	return updateManifest(filepath.Join(pastStreamsDir, folder), func(m *PastStream) {
+		m.Pinned = pinned
+	})
+}
+
+// archiveLiveRecording moves the live output into past streams. If that fails the
+// recording stays where it is and is retried by the janitor and before the next stream.
+func archiveLiveRecording(folder string) {
+	if err := archiveStream(liveDir, folder); err != nil {
+		reportStorageIssue("archive", folder, err)
+		storage.Lock()
+		storage.pending = &pendingArchive{source: liveDir, folder: folder}
+		storage.Unlock()
+	}
+}
+
+// retryPendingArchive tries a failed archive again. Before a new stream starts the
+// recording must leave web/live, so it is set aside if it still can't be archived.
+func retryPendingArchive(beforeStream bool) {
+	storage.Lock()
+	pending := storage.pending
+	storage.Unlock()
+	if pending == nil {
+		return
+	}
+
+	log.Printf("Retrying archive of %s...", pending.folder)
+	err := archiveStream(pending.source, pending.folder)
+	if err == nil {
+		storage.Lock()
+		storage.pending = nil
+		storage.Unlock()
+		if pending.source != liveDir {
+			os.Remove(pending.source)
+		}
+		return
+	}
+	reportStorageIssue("archive", pending.folder, err)
+
+	if !beforeStream || pending.source != liveDir {
+		return
+	}
+
+	// Renaming within the same filesystem needs no free space
+	aside := filepath.Join(filepath.Dir(liveDir), unarchivedPrefix+filepath.Base(pending.folder))
+	if err := os.Rename(liveDir, aside); err != nil {
+		reportStorageIssue("set aside", liveDir, err)
+		return
+	}
+	log.Printf("Moved the unarchived recording to %s so the new stream doesn't overwrite it", aside)
+
+	storage.Lock()
+	storage.pending = &pendingArchive{source: aside, folder: pending.folder}
+	storage.Unlock()
+}
+
+// enforceRetention deletes unpinned recordings older than keep_days, then the oldest
+// unpinned ones until the archive fits in keep_gb
+func enforceRetention() {
+	keepDays := streamConfig.Archive.KeepDays
+	quota := int64(streamConfig.Archive.KeepGB * bytesPerGigabyte)
+	if keepDays <= 0 && quota <= 0 {
+		return
+	}
+
+	// Don't delete a recording while it is being remuxed
+	remuxMutex.Lock()
+	defer remuxMutex.Unlock()
+
+	recordings := archivedRecordings()
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].archivedAt.Before(recordings[j].archivedAt)
+	})
+
+	var total int64
+	for _, r := range recordings {
+		total += r.size
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	for _, r := range recordings {
+		if r.manifest.Pinned {
+			continue
+		}
+		expired := keepDays > 0 && r.archivedAt.Before(cutoff)
+		overQuota := quota > 0 && total > quota
+		if !expired && !overQuota {
+			continue
+		}
+
+		if err := os.RemoveAll(r.folder); err != nil {
+			reportStorageIssue("retention", r.folder, err)
+			continue
+		}
+		total -= r.size
+		if expired {
+			log.Printf("Retention: deleted %s, older than %d days", r.folder, keepDays)
+		} else {
+			log.Printf("Retention: deleted %s to stay under %s", r.folder, formatBytes(quota))
+		}
+	}
+
+	if quota > 0 && total > quota {
+		log.Printf("Retention: archive is %s over its quota, the rest is pinned", formatBytes(total-quota))
+	}
+	invalidatePastStreams()
+}
+
+type archivedRecording struct {
+	folder     string
+	manifest   PastStream
+	size       int64
+	archivedAt time.Time
+}
+
+func archivedRecordings() []archivedRecording {
+	var recordings []archivedRecording
+	for _, folder := range archiveFolders() {
+		manifest, err := readManifest(folder)
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, archivedRecording{
+			folder:     folder,
+			manifest:   manifest,
+			size:       folderSize(folder),
+			archivedAt: archivedAt(folder, manifest),
+		})
+	}
+	return recordings
+}
+
+func formatBytes(n int64) string {
+	return fmt.Sprintf("%.2f GB", float64(n)/bytesPerGigabyte)
+}
@@ -0,0 +1,367 @@
+package stream
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TranscoderState describes what the supervised FFmpeg process is doing
+type TranscoderState string
+
+const (
+	TranscoderStopped    TranscoderState = "stopped"
+	TranscoderRunning    TranscoderState = "running"
+	TranscoderRestarting TranscoderState = "restarting"
+	TranscoderStopping   TranscoderState = "stopping"
+	TranscoderFailed     TranscoderState = "failed"
+)
+
+const (
+	maxFastRestarts   = 5                // Give up after this many crashes in a row
+	stableRunDuration = 30 * time.Second // A run this long resets the backoff
+	maxRestartBackoff = 30 * time.Second
+	transcoderLogSize = 50 // Recent stderr lines kept for the API
+)
+
+// TranscoderProgress is the latest progress report parsed from FFmpeg
+type TranscoderProgress struct {
+	Frame         int64     `json:"frame"`
+	FPS           float64   `json:"fps"`
+	BitrateKbps   float64   `json:"bitrate_kbps"`
+	DroppedFrames int64     `json:"dropped_frames"`
+	DupFrames     int64     `json:"dup_frames"`
+	TotalSize     int64     `json:"total_size"`
+	OutTime       string    `json:"out_time"`
+	Speed         string    `json:"speed"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TranscoderStatus is a snapshot of the supervisor exposed through the API
+type TranscoderStatus struct {
+	State     TranscoderState    `json:"state"`
+	PID       int                `json:"pid,omitempty"`
+	StartedAt time.Time          `json:"started_at,omitempty"`
+	Restarts  int                `json:"restarts"`
+	LastExit  string             `json:"last_exit,omitempty"`
+	Progress  TranscoderProgress `json:"progress"`
+	RecentLog []string           `json:"recent_log,omitempty"` // Admins only
+}
+
+// transcoder supervises a single FFmpeg process, restarting it when it crashes
+type transcoder struct {
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	state     TranscoderState
+	startedAt time.Time
+	restarts  int
+	lastExit  string
+	progress  TranscoderProgress
+	logLines  []string
+
+	attachInput func(io.WriteCloser) error // Connects FFmpeg's stdin to the ingest
+	detachInput func()                     // Closes FFmpeg's stdin
+	onFailed    func()                     // Called when the supervisor gives up
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+var hlsTranscoder = &transcoder{
+	state:       TranscoderStopped,
+	attachInput: ingest.attach,
+	detachInput: ingest.detach,
+	onFailed: func() {
+		// The latest publisher state wins, a busy stream loop doesn't hold the supervisor up
+		signalPublish(false)
+	},
+}
+
+// GetTranscoderStatus returns a snapshot of the HLS transcoder
+func GetTranscoderStatus() TranscoderStatus {
+	return hlsTranscoder.status()
+}
+
+func (t *transcoder) status() TranscoderStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := TranscoderStatus{
+		State:     t.state,
+		StartedAt: t.startedAt,
+		Restarts:  t.restarts,
+		LastExit:  t.lastExit,
+		Progress:  t.progress,
+		RecentLog: append([]string(nil), t.logLines...),
+	}
+	if t.cmd != nil && t.cmd.Process != nil {
+		status.PID = t.cmd.Process.Pid
+	}
+	return status
+}
+
+// start launches FFmpeg with the given arguments and keeps it running until stop
+func (t *transcoder) start(args []string) {
+	t.mu.Lock()
+	if t.done != nil {
+		t.mu.Unlock()
+		log.Println("Transcoder already running, ignoring start")
+		return
+	}
+	t.restarts = 0
+	t.lastExit = ""
+	t.logLines = nil
+	t.progress = TranscoderProgress{}
+	t.stopCh = make(chan struct{})
+	t.done = make(chan struct{})
+	t.mu.Unlock()
+
+	go t.supervise(args)
+}
+
+func (t *transcoder) supervise(args []string) {
+	defer func() {
+		t.mu.Lock()
+		close(t.done)
+		t.done = nil
+		t.mu.Unlock()
+	}()
+
+	backoff := time.Second
+	failures := 0
+
+	for {
+		runStarted := time.Now()
+		err := t.runOnce(args)
+
+		select {
+		case <-t.stopCh:
+			t.setState(TranscoderStopped)
+			return
+		default:
+		}
+
+		exit := "exited cleanly"
+		if err != nil {
+			exit = err.Error()
+		}
+		log.Printf("Transcoder: FFmpeg stopped unexpectedly (%s)", exit)
+
+		if time.Since(runStarted) >= stableRunDuration {
+			backoff = time.Second
+			failures = 0
+		}
+		failures++
+
+		t.mu.Lock()
+		t.lastExit = exit
+		t.restarts++
+		t.mu.Unlock()
+
+		if failures > maxFastRestarts {
+			log.Printf("Transcoder: giving up after %d consecutive failures", failures-1)
+			t.setState(TranscoderFailed)
+			if t.onFailed != nil {
+				t.onFailed()
+			}
+			return
+		}
+
+		t.setState(TranscoderRestarting)
+		log.Printf("Transcoder: restarting FFmpeg in %s", backoff)
+
+		select {
+		case <-t.stopCh:
+			t.setState(TranscoderStopped)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// runOnce starts FFmpeg, wires up its pipes and blocks until it exits
+func (t *transcoder) runOnce(args []string) error {
+	fullArgs := append([]string{"-hide_banner", "-nostats", "-loglevel", "warning", "-progress", "pipe:1"}, args...)
+	cmd := exec.Command("ffmpeg", fullArgs...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.cmd = cmd
+	t.state = TranscoderRunning
+	t.startedAt = time.Now()
+	t.mu.Unlock()
+
+	log.Printf("Transcoder: FFmpeg started with pid %d", cmd.Process.Pid)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.readProgress(stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		t.readLog(stderr)
+	}()
+
+	if t.attachInput != nil {
+		if err := t.attachInput(stdin); err != nil {
+			log.Printf("Transcoder: failed to attach input: %v", err)
+		}
+	}
+
+	// Pipes must be drained before Wait closes them
+	wg.Wait()
+	err = cmd.Wait()
+
+	t.mu.Lock()
+	t.cmd = nil
+	t.mu.Unlock()
+
+	return err
+}
+
+// stop closes FFmpeg's input, then interrupts and finally kills it if it does not exit
+func (t *transcoder) stop() {
+	t.mu.Lock()
+	done := t.done
+	if done == nil {
+		t.mu.Unlock()
+		log.Println("No active FFmpeg process found to terminate.")
+		return
+	}
+	close(t.stopCh)
+	t.state = TranscoderStopping
+	t.mu.Unlock()
+
+	// End of input lets FFmpeg flush the final segment and playlist
+	if t.detachInput != nil {
+		t.detachInput()
+	}
+
+	select {
+	case <-done:
+		log.Println("FFmpeg process exited after end of input.")
+		return
+	case <-time.After(5 * time.Second):
+	}
+
+	log.Println("FFmpeg still running, sending interrupt...")
+	t.signal(os.Interrupt)
+
+	select {
+	case <-done:
+		log.Println("FFmpeg process exited after interrupt.")
+		return
+	case <-time.After(10 * time.Second):
+	}
+
+	log.Println("FFmpeg ignored interrupt, killing it...")
+	t.signal(os.Kill)
+	<-done
+}
+
+func (t *transcoder) signal(sig os.Signal) {
+	t.mu.Lock()
+	cmd := t.cmd
+	t.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	if err := cmd.Process.Signal(sig); err != nil {
+		// Interrupt is not supported everywhere, fall back to kill
+		log.Printf("Failed to signal FFmpeg (%v): %v", sig, err)
+		cmd.Process.Kill()
+	}
+}
+
+func (t *transcoder) setState(state TranscoderState) {
+	t.mu.Lock()
+	t.state = state
+	t.mu.Unlock()
+}
+
+// readProgress parses the key=value blocks written by -progress
+func (t *transcoder) readProgress(r io.Reader) {
+	var current TranscoderProgress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			current.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			current.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			current.BitrateKbps, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "drop_frames":
+			current.DroppedFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "dup_frames":
+			current.DupFrames, _ = strconv.ParseInt(value, 10, 64)
+		case "total_size":
+			current.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time":
+			current.OutTime = value
+		case "speed":
+			current.Speed = value
+		case "progress":
+			// Each block ends with progress=continue or progress=end
+			current.UpdatedAt = time.Now()
+			t.mu.Lock()
+			t.progress = current
+			t.mu.Unlock()
+		}
+	}
+}
+
+// readLog keeps the most recent stderr lines and mirrors them to the server log
+func (t *transcoder) readLog(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		log.Printf("ffmpeg: %s", line)
+
+		t.mu.Lock()
+		t.logLines = append(t.logLines, line)
+		if len(t.logLines) > transcoderLogSize {
+			t.logLines = t.logLines[len(t.logLines)-transcoderLogSize:]
+		}
+		t.mu.Unlock()
+	}
+}
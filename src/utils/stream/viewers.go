@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"goFrame/src/utils"
+	"goFrame/src/utils/stream/nostr"
+)
+
+// ViewerCounts is the audience of the current stream
+type ViewerCounts struct {
+	Current int `json:"current_participants"` // Unique viewers inside the sliding window
+	Total   int `json:"total_participants"`   // Unique viewers since the stream started
+}
+
+type viewerTracker struct {
+	mu       sync.Mutex
+	lastSeen map[string]time.Time // Viewer fingerprint -> last playlist fetch
+	total    map[string]bool
+	stop     chan struct{}
+}
+
+var viewers = &viewerTracker{
+	lastSeen: make(map[string]time.Time),
+	total:    make(map[string]bool),
+}
+
+// TrackViewer records a playlist fetch from the live HLS output
+func TrackViewer(r *http.Request) {
+	switch lifecycle.current() {
+	case StateStarting, StateLive:
+	default:
+		return
+	}
+
+	id := viewerFingerprint(r)
+
+	viewers.mu.Lock()
+	viewers.lastSeen[id] = time.Now()
+	viewers.total[id] = true
+	viewers.mu.Unlock()
+}
+
+// GetViewerCounts returns the current and total unique viewers
+func GetViewerCounts() ViewerCounts {
+	return viewers.counts()
+}
+
+// viewersOnStateChange resets the counts for a new stream and publishes them while live
+func viewersOnStateChange(t StateTransition) {
+	switch {
+	case t.To == StateStarting && !t.Resumed:
+		viewers.reset()
+	case t.To == StateLive:
+		viewers.startPublishing()
+	case t.To == StateEnding:
+		viewers.stopPublishing()
+	}
+}
+
+// viewerFingerprint identifies a viewer without storing their address
+func viewerFingerprint(r *http.Request) string {
+	hash := sha256.Sum256([]byte(utils.ClientIP(r) + "|" + r.UserAgent()))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (v *viewerTracker) counts() ViewerCounts {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cutoff := time.Now().Add(-viewerWindow())
+	for id, seen := range v.lastSeen {
+		if seen.Before(cutoff) {
+			delete(v.lastSeen, id)
+		}
+	}
+	return ViewerCounts{Current: len(v.lastSeen), Total: len(v.total)}
+}
+
+func (v *viewerTracker) reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lastSeen = make(map[string]time.Time)
+	v.total = make(map[string]bool)
+}
+
+// startPublishing re-publishes the live event whenever the counts change, at most once per interval
+func (v *viewerTracker) startPublishing() {
+	v.mu.Lock()
+	if v.stop != nil {
+		v.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	v.stop = stop
+	v.mu.Unlock()
+
+	interval := time.Duration(streamConfig.Viewers.PublishInterval) * time.Second
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var published ViewerCounts
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			counts := v.counts()
+			if counts == published {
+				continue
+			}
+			published = counts
+
+			metadataMutex.Lock()
+			metadataConfig.CurrentParticipants = counts.Current
+			metadataConfig.TotalParticipants = counts.Total
+			if err := saveMetadata(liveMetadataFile); err != nil {
+				log.Printf("Failed to save viewer counts: %v", err)
+			}
+			event := metadataConfig.liveEvent()
+			metadataMutex.Unlock()
+
+			nostr.BroadcastNostrUpdateEvent(event)
+		}
+	}()
+}
+
+func (v *viewerTracker) stopPublishing() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stop != nil {
+		close(v.stop)
+		v.stop = nil
+	}
+}
+
+// viewerWindow is how long a viewer counts as watching after their last playlist fetch
+func viewerWindow() time.Duration {
+	return time.Duration(streamConfig.Viewers.Window) * time.Second
+}
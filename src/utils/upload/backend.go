@@ -0,0 +1,107 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+// File is an upload streaming to a backend. Body can only be read once and is
+// hashed as it is read, so the hash is complete once Body has returned io.EOF.
+type File struct {
+	Name        string // Original file name, only used for its extension
+	ContentType string
+	Size        int64 // -1 until the whole body has been read
+	Body        io.Reader
+	Expires     time.Time // Zero to keep the file, backends that can expire files themselves are told
+	digest      func() string
+}
+
+// SHA256 is the hex digest of Body, only valid after Body has been read to the end
+func (f File) SHA256() string {
+	return f.digest()
+}
+
+// Stored is where a backend put a file
+type Stored struct {
+	URL         string // Absolute, or a path on this site for files we serve ourselves
+	Token       string // Management token, if the backend issues one
+	SHA256      string // Filled in by Receive
+	Size        int64
+	ContentType string
+
+	// Images only, see Receive
+	OriginalSHA256 string // Before metadata was stripped
+	Dim            string // "<width>x<height>"
+	Blurhash       string
+	Thumb          *Stored // Nil when no thumbnail could be made
+}
+
+// UploadBackend stores uploaded files somewhere clients can fetch them
+type UploadBackend interface {
+	Name() string
+	Upload(ctx context.Context, f File) (Stored, error)
+}
+
+// Deleter is implemented by backends that can remove a file they stored
+type Deleter interface {
+	Delete(ctx context.Context, r Record) error
+}
+
+// ConfiguredBackend returns the backend selected by upload.backend in config.yml
+func ConfiguredBackend() (UploadBackend, error) {
+	return backendNamed(utils.AppConfig.Upload.Backend)
+}
+
+// backendNamed returns a backend by name, configured from config.yml
+func backendNamed(name string) (UploadBackend, error) {
+	cfg := utils.AppConfig.Upload
+
+	switch strings.ToLower(name) {
+	case "", "0x0":
+		return newZeroX0Backend(cfg.ZeroX0), nil
+	case "local":
+		return newLocalBackend(cfg.Local), nil
+	case "blossom":
+		if len(cfg.Blossom.Servers) == 0 {
+			return nil, fmt.Errorf("upload.blossom.servers is empty")
+		}
+		return &blossomBackend{servers: cfg.Blossom.Servers}, nil
+	case "s3":
+		return newS3Backend(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown upload backend %q", name)
+	}
+}
+
+// MaxSize is the largest upload accepted, in bytes
+func MaxSize() int64 {
+	if mb := utils.AppConfig.Upload.MaxSizeMB; mb > 0 {
+		return mb << 20
+	}
+	return 512 << 20
+}
+
+var safeExtension = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// objectName names a stored file after its hash, keeping a recognisable extension
+func objectName(f File) string {
+	return f.SHA256() + extension(f)
+}
+
+func extension(f File) string {
+	if ext := strings.ToLower(filepath.Ext(f.Name)); safeExtension.MatchString(ext) {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(f.ContentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+func TestLocalPutAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	backend := newLocalBackend(utils.LocalUploadConfig{Dir: dir})
+
+	data := []byte("hello, local disk")
+	stored, err := backend.Upload(context.Background(), testFile("hello.txt", "text/plain", data))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	name := sha256Hex(data) + ".txt"
+	if stored.URL != LocalURLPrefix+name {
+		t.Errorf("URL = %s, want %s", stored.URL, LocalURLPrefix+name)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("stored file = %q, %v", got, err)
+	}
+
+	// The same content again ends up in the same file
+	again, err := backend.Upload(context.Background(), testFile("copy.txt", "text/plain", data))
+	if err != nil || again.URL != stored.URL {
+		t.Errorf("second upload = %s, %v, want %s", again.URL, err, stored.URL)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the upload directory, want 1", len(entries))
+	}
+
+	if err := backend.Delete(context.Background(), Record{URL: stored.URL}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Errorf("file still there after Delete: %v", err)
+	}
+	if err := backend.Delete(context.Background(), Record{URL: stored.URL}); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+}
+
+// zeroX0StandIn answers uploads and deletes like https://0x0.st
+type zeroX0StandIn struct {
+	standIn
+	tokens  map[string]string
+	expires map[string]string
+}
+
+func newZeroX0StandIn(t *testing.T) *zeroX0StandIn {
+	s := &zeroX0StandIn{
+		tokens:  make(map[string]string),
+		expires: make(map[string]string),
+	}
+	s.start(t, s.handle)
+	return s
+}
+
+func (s *zeroX0StandIn) expiry(path string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expires[path]
+}
+
+func (s *zeroX0StandIn) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Deleting is a POST to the file's URL with its token
+	if r.URL.Path != "/" {
+		r.ParseForm()
+		if _, ok := s.files[r.URL.Path]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if _, ok := r.PostForm["delete"]; !ok || r.PostForm.Get("token") != s.tokens[r.URL.Path] {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		delete(s.files, r.URL.Path)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "no file", http.StatusBadRequest)
+		return
+	}
+	data, _ := io.ReadAll(file)
+	if _, ok := r.MultipartForm.Value["secret"]; !ok {
+		http.Error(w, "expected a secret URL", http.StatusBadRequest)
+		return
+	}
+
+	path := "/" + strconv.Itoa(len(s.files)) + filepath.Ext(header.Filename)
+	s.files[path] = data
+	s.tokens[path] = "token-" + path
+	s.expires[path] = r.FormValue("expires")
+
+	w.Header().Set("X-Token", s.tokens[path])
+	io.WriteString(w, s.URL+path+"\n")
+}
+
+func TestZeroX0PutAndDelete(t *testing.T) {
+	server := newZeroX0StandIn(t)
+	backend := newZeroX0Backend(utils.ZeroX0UploadConfig{URL: server.URL})
+
+	data := []byte("hello, 0x0")
+	f := testFile("hello.txt", "text/plain", data)
+	f.Expires = time.UnixMilli(1893456000000)
+
+	stored, err := backend.Upload(context.Background(), f)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if stored.URL != server.URL+"/0.txt" {
+		t.Errorf("URL = %q, want %s/0.txt", stored.URL, server.URL)
+	}
+	if stored.Token != "token-/0.txt" {
+		t.Errorf("Token = %q", stored.Token)
+	}
+	if got, _ := server.file("/0.txt"); !bytes.Equal(got, data) {
+		t.Errorf("stored %q, want %q", got, data)
+	}
+	if got := server.expiry("/0.txt"); got != "1893456000000" {
+		t.Errorf("expires = %q, want milliseconds since the epoch", got)
+	}
+
+	record := Record{URL: stored.URL, Token: stored.Token}
+	if err := backend.Delete(context.Background(), record); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := server.file("/0.txt"); ok {
+		t.Error("file still stored after Delete")
+	}
+	if err := backend.Delete(context.Background(), record); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+	if err := backend.Delete(context.Background(), Record{URL: stored.URL}); err == nil {
+		t.Error("Delete without a token succeeded")
+	}
+}
@@ -0,0 +1,54 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"goFrame/src/utils/stream/nostr"
+)
+
+// blossomBackend stores files on the first Blossom server that accepts them
+type blossomBackend struct {
+	servers []string
+}
+
+func (b *blossomBackend) Name() string { return "blossom" }
+
+func (b *blossomBackend) Upload(ctx context.Context, f File) (Stored, error) {
+	// The authorization covers the hash, so the file has to be read before sending it
+	f, cleanup, err := spool(f)
+	if err != nil {
+		return Stored{}, err
+	}
+	defer cleanup()
+	seeker := f.Body.(io.Seeker)
+
+	var failures []string
+	for i, server := range b.servers {
+		if i > 0 {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return Stored{}, err
+			}
+		}
+
+		// The HTTP client closes request bodies, the spooled file has to survive for the next server
+		blob, err := nostr.BlossomUpload(server, io.NopCloser(f.Body), f.Size, f.SHA256(), f.ContentType, f.Name)
+		if err == nil {
+			return Stored{URL: blob.URL}, nil
+		}
+		failures = append(failures, err.Error())
+	}
+	return Stored{}, fmt.Errorf("no Blossom server accepted the upload: %s", strings.Join(failures, "; "))
+}
+
+// Delete removes the blob from the server it was stored on
+func (b *blossomBackend) Delete(ctx context.Context, r Record) error {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return err
+	}
+	return nostr.BlossomDelete(u.Scheme+"://"+u.Host, r.SHA256)
+}
@@ -0,0 +1,260 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTooLarge is returned when an upload is bigger than its size limit
+	ErrTooLarge = errors.New("file too large")
+	// ErrInvalidImage is returned when a file that looks like an image can't be parsed
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// Incoming is an upload as the client sends it
+type Incoming struct {
+	Name        string
+	ContentType string
+	Body        io.Reader
+	Limit       int64     // Largest accepted size in bytes
+	Expires     time.Time // Zero to keep the file
+}
+
+// Receive streams an upload to the backend through a pipe, hashing it, enforcing the
+// size limit and running the upload filter on the way, so only a small buffer of the
+// file is ever held in memory. A file the filter refuses is cut off before its end
+// reaches the backend, so it is never stored.
+func Receive(ctx context.Context, backend UploadBackend, in Incoming) (Stored, error) {
+	body, contentType, limit := in.Body, in.ContentType, in.Limit
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(body, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Stored{}, err
+	}
+	sniff = sniff[:n]
+
+	if err := checkType(in.Name, in.ContentType, sniff); err != nil {
+		return Stored{}, err
+	}
+	inspect, err := newInspector()
+	if err != nil {
+		return Stored{}, err
+	}
+	defer inspect.close()
+
+	// Browsers send application/octet-stream for anything they don't recognise
+	if contentType == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+		contentType = http.DetectContentType(sniff)
+	}
+
+	source := io.LimitReader(io.MultiReader(bytes.NewReader(sniff), body), limit+1)
+
+	// Images are recognised by their content, whatever the client claims
+	if kind := http.DetectContentType(sniff); processableImage(kind) {
+		return receiveImage(ctx, backend, in, kind, source, inspect)
+	}
+
+	sum := sha256.New()
+	reader, writer := io.Pipe()
+
+	type copyResult struct {
+		size int64
+		err  error
+	}
+	copied := make(chan copyResult, 1)
+	go func() {
+		// The hash is written first so it is complete by the time the backend sees EOF
+		size, err := io.Copy(io.MultiWriter(sum, inspect, writer), source)
+		if err == nil && size > limit {
+			err = ErrTooLarge
+		}
+		if err == nil {
+			err = inspect.finish(hex.EncodeToString(sum.Sum(nil)))
+		}
+		writer.CloseWithError(err)
+		copied <- copyResult{size, err}
+	}()
+
+	f := File{
+		Name:        in.Name,
+		ContentType: contentType,
+		Size:        -1,
+		Body:        reader,
+		Expires:     in.Expires,
+		digest:      func() string { return hex.EncodeToString(sum.Sum(nil)) },
+	}
+	stored, uploadErr := backend.Upload(ctx, f)
+
+	// Unblock the copy if the backend stopped reading early
+	reader.CloseWithError(io.ErrClosedPipe)
+	result := <-copied
+
+	// A failure reading the upload explains whatever the backend ran into
+	if result.err != nil && result.err != io.ErrClosedPipe {
+		return Stored{}, result.err
+	}
+	if uploadErr != nil {
+		return Stored{}, uploadErr
+	}
+	stored.SHA256 = f.SHA256()
+	stored.Size = result.size
+	stored.ContentType = contentType
+	return stored, nil
+}
+
+// receiveImage stores an image without its metadata, with its dimensions, blurhash and a
+// thumbnail. Stripping needs the whole file, so images are spooled to disk instead of piped.
+func receiveImage(ctx context.Context, backend UploadBackend, in Incoming, contentType string, source io.Reader, inspect *inspector) (Stored, error) {
+	originalSum := sha256.New()
+	original, size, cleanupOriginal, err := spoolReader(io.TeeReader(source, io.MultiWriter(originalSum, inspect)))
+	if err != nil {
+		return Stored{}, err
+	}
+	defer cleanupOriginal()
+	if size > in.Limit {
+		return Stored{}, ErrTooLarge
+	}
+	originalSHA := hex.EncodeToString(originalSum.Sum(nil))
+	if err := inspect.finish(originalSHA); err != nil {
+		return Stored{}, err
+	}
+
+	stripped, _, cleanupStripped, err := spoolReader(nil)
+	if err != nil {
+		return Stored{}, err
+	}
+	defer cleanupStripped()
+	orientation, err := stripImageMetadata(stripped, original, contentType)
+	if err != nil {
+		return Stored{}, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	strippedSHA, strippedSize, err := hashSeeker(stripped)
+	if err != nil {
+		return Stored{}, err
+	}
+	if blocked(strippedSHA) {
+		return Stored{}, fmt.Errorf("%w: the file is on the blocklist", ErrRejected)
+	}
+	stored, err := backend.Upload(ctx, File{
+		Name:        in.Name,
+		ContentType: contentType,
+		Size:        strippedSize,
+		Body:        stripped,
+		Expires:     in.Expires,
+		digest:      func() string { return strippedSHA },
+	})
+	if err != nil {
+		return Stored{}, err
+	}
+	stored.SHA256 = strippedSHA
+	stored.Size = strippedSize
+	stored.ContentType = contentType
+	stored.OriginalSHA256 = originalSHA
+
+	// The file is stored, everything past here is optional
+	info, err := describeImage(stripped.Name(), contentType, orientation)
+	if err != nil {
+		log.Printf("Failed to read image %s: %v", in.Name, err)
+	}
+	if info.Width > 0 && info.Height > 0 {
+		stored.Dim = fmt.Sprintf("%dx%d", info.Width, info.Height)
+	}
+	stored.Blurhash = info.Blurhash
+	if info.Thumb != nil {
+		thumbSHA := sha256.Sum256(info.Thumb)
+		thumb, err := backend.Upload(ctx, File{
+			Name:        "thumb.jpg",
+			ContentType: "image/jpeg",
+			Size:        int64(len(info.Thumb)),
+			Body:        bytes.NewReader(info.Thumb),
+			Expires:     in.Expires,
+			digest:      func() string { return hex.EncodeToString(thumbSHA[:]) },
+		})
+		if err != nil {
+			log.Printf("Failed to store thumbnail for %s: %v", in.Name, err)
+		} else {
+			thumb.SHA256 = hex.EncodeToString(thumbSHA[:])
+			thumb.Size = int64(len(info.Thumb))
+			thumb.ContentType = "image/jpeg"
+			stored.Thumb = &thumb
+		}
+	}
+	return stored, nil
+}
+
+// spool writes a streamed file to disk for backends that need its size and hash before
+// sending it, or that have to send it more than once. cleanup removes the copy.
+func spool(f File) (File, func(), error) {
+	// Files that are already on disk don't need another copy
+	if _, ok := f.Body.(*os.File); ok && f.Size >= 0 {
+		return f, func() {}, nil
+	}
+
+	tmp, size, cleanup, err := spoolReader(f.Body)
+	if err != nil {
+		return File{}, nil, fmt.Errorf("spool upload: %w", err)
+	}
+	f.Body = tmp
+	f.Size = size
+	return f, cleanup, nil
+}
+
+// spoolReader copies r into a hidden temporary file in the upload directory and rewinds
+// it, a nil r just creates the file. cleanup closes and removes it.
+func spoolReader(r io.Reader) (*os.File, int64, func(), error) {
+	dir := LocalDir()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, 0, nil, err
+	}
+	// Not os.TempDir, which is often a tmpfs held in memory
+	tmp, err := os.CreateTemp(dir, ".spool-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if r == nil {
+		return tmp, 0, cleanup, nil
+	}
+
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+// hashSeeker hashes a file from the start and rewinds it
+func hashSeeker(f io.ReadSeeker) (string, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	sum := sha256.New()
+	size, err := io.Copy(sum, f)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), size, nil
+}
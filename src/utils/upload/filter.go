@@ -0,0 +1,314 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+const (
+	defaultBlocklist = "data/upload-blocklist.txt"
+	clamdTimeout     = 30 * time.Second
+
+	// Readers look for a PDF header in the first KiB of a file and for a ZIP
+	// directory in its last 64 KiB, after which a file is also read as those formats
+	pdfHeadSize = 1024
+	zipTailSize = 22 + 65535
+)
+
+var (
+	// ErrTypeNotAllowed is returned for executables and for types the upload filter doesn't allow
+	ErrTypeNotAllowed = errors.New("file type not allowed")
+	// ErrRejected is returned for polyglot files, blocklisted files and malware
+	ErrRejected = errors.New("file rejected")
+	// ErrScanFailed is returned when clamd is configured but couldn't scan the file
+	ErrScanFailed = errors.New("virus scan failed")
+)
+
+// executableMagic are the first bytes of native executables and scripts
+var executableMagic = [][]byte{
+	[]byte("MZ"),             // Windows and DOS
+	[]byte("\x7fELF"),        // Linux and BSD
+	{0xfe, 0xed, 0xfa, 0xce}, // Mach-O
+	{0xfe, 0xed, 0xfa, 0xcf},
+	{0xce, 0xfa, 0xed, 0xfe},
+	{0xcf, 0xfa, 0xed, 0xfe},
+	{0xca, 0xfe, 0xba, 0xbe}, // Universal Mach-O and Java classes
+	[]byte("#!"),             // Scripts
+}
+
+// executableExtensions are run rather than opened when clicked
+var executableExtensions = map[string]bool{
+	".exe": true, ".com": true, ".scr": true, ".pif": true, ".dll": true, ".cpl": true,
+	".bat": true, ".cmd": true, ".msi": true, ".msp": true, ".lnk": true, ".hta": true,
+	".vbs": true, ".vbe": true, ".wsf": true, ".ps1": true, ".jar": true, ".apk": true,
+}
+
+var executableTypes = map[string]bool{
+	"application/x-msdownload":                true,
+	"application/x-msdos-program":             true,
+	"application/x-dosexec":                   true,
+	"application/x-msi":                       true,
+	"application/x-executable":                true,
+	"application/x-elf":                       true,
+	"application/x-mach-binary":               true,
+	"application/x-sh":                        true,
+	"application/java-archive":                true,
+	"application/vnd.android.package-archive": true,
+}
+
+// markup makes browsers render a file as a page when they sniff its type
+var markup = []string{"<html", "<!doctype html", "<script", "<svg", "<iframe"}
+
+var blocklist = struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	hashes  map[string]bool
+}{}
+
+// checkType refuses executables and types the filter doesn't allow. Every type the file
+// could be served as has to pass: its sniffed type, the type the client claims and the
+// type of its extension.
+func checkType(name, claimed string, sniff []byte) error {
+	for _, magic := range executableMagic {
+		if bytes.HasPrefix(sniff, magic) {
+			return fmt.Errorf("%w: executables can't be uploaded", ErrTypeNotAllowed)
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if executableExtensions[ext] {
+		return fmt.Errorf("%w: executables can't be uploaded", ErrTypeNotAllowed)
+	}
+
+	filter := utils.AppConfig.Upload.Filter
+	for _, t := range fileTypes(ext, claimed, sniff) {
+		if executableTypes[t] {
+			return fmt.Errorf("%w: executables can't be uploaded", ErrTypeNotAllowed)
+		}
+		if matchesType(t, filter.DenyTypes) || (len(filter.AllowTypes) > 0 && !matchesType(t, filter.AllowTypes)) {
+			return fmt.Errorf("%w: %s", ErrTypeNotAllowed, t)
+		}
+	}
+	return nil
+}
+
+// fileTypes lists the distinct media types a file could be taken for, without parameters
+func fileTypes(ext, claimed string, sniff []byte) []string {
+	var types []string
+	for _, t := range []string{http.DetectContentType(sniff), claimed, mime.TypeByExtension(ext)} {
+		mediaType, _, err := mime.ParseMediaType(t)
+		if err != nil || mediaType == "application/octet-stream" || slices.Contains(types, mediaType) {
+			continue
+		}
+		types = append(types, mediaType)
+	}
+	if len(types) == 0 {
+		return []string{"application/octet-stream"}
+	}
+	return types
+}
+
+// matchesType reports whether a media type matches one of the patterns, e.g. "image/png" or "image/*"
+func matchesType(mediaType string, patterns []string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == mediaType || (strings.HasSuffix(p, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// inspector looks at an upload as it streams past, keeping its first and last bytes and
+// passing it on to clamd. finish has the verdict once the whole file has been written.
+type inspector struct {
+	head     []byte
+	tail     []byte
+	clamd    net.Conn
+	clamdErr error
+}
+
+// newInspector connects to clamd when upload.filter.clamd is set
+func newInspector() (*inspector, error) {
+	i := &inspector{}
+	addr := utils.AppConfig.Upload.Filter.Clamd
+	if addr == "" {
+		return i, nil
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, addr, clamdTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	conn.SetDeadline(time.Now().Add(clamdTimeout))
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	i.clamd = conn
+	return i, nil
+}
+
+// Write never fails, a scan that breaks off is reported by finish
+func (i *inspector) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(i.head) < pdfHeadSize {
+		i.head = append(i.head, p[:min(len(p), pdfHeadSize-len(i.head))]...)
+	}
+	i.tail = append(i.tail, p...)
+	if len(i.tail) > 2*zipTailSize {
+		i.tail = append(i.tail[:0], i.tail[len(i.tail)-zipTailSize:]...)
+	}
+
+	if i.clamd != nil && i.clamdErr == nil {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(p)))
+		i.clamd.SetDeadline(time.Now().Add(clamdTimeout))
+		chunk := net.Buffers{size[:], p}
+		if _, err := chunk.WriteTo(i.clamd); err != nil {
+			i.clamdErr = err
+		}
+	}
+	return len(p), nil
+}
+
+// finish checks the whole file, given its SHA-256, and closes the connection to clamd
+func (i *inspector) finish(sha string) error {
+	defer i.close()
+
+	detected := http.DetectContentType(i.head)
+	if detected != "application/zip" && hasZipDirectory(i.tail) {
+		return fmt.Errorf("%w: the file also contains a ZIP archive", ErrRejected)
+	}
+	if detected != "application/pdf" && bytes.Contains(i.head, []byte("%PDF-")) {
+		return fmt.Errorf("%w: the file also contains a PDF", ErrRejected)
+	}
+	if !strings.HasPrefix(detected, "text/") {
+		head := strings.ToLower(string(i.head))
+		for _, tag := range markup {
+			if strings.Contains(head, tag) {
+				return fmt.Errorf("%w: the file also contains a web page", ErrRejected)
+			}
+		}
+	}
+	if blocked(sha) {
+		return fmt.Errorf("%w: the file is on the blocklist", ErrRejected)
+	}
+	return i.scanResult()
+}
+
+// scanResult ends the stream to clamd and reads its verdict
+func (i *inspector) scanResult() error {
+	if i.clamd == nil {
+		return nil
+	}
+	i.clamd.SetDeadline(time.Now().Add(clamdTimeout))
+	if i.clamdErr == nil {
+		_, i.clamdErr = i.clamd.Write([]byte{0, 0, 0, 0})
+	}
+	// clamd explains itself when it hangs up early, e.g. past its StreamMaxLength
+	reply, err := bufio.NewReader(i.clamd).ReadString(0)
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return fmt.Errorf("%w: malware detected (%s)", ErrRejected, signature)
+	case reply == "stream: OK" && i.clamdErr == nil:
+		return nil
+	case reply != "":
+		return fmt.Errorf("%w: %s", ErrScanFailed, reply)
+	case i.clamdErr != nil:
+		return fmt.Errorf("%w: %v", ErrScanFailed, i.clamdErr)
+	default:
+		return fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+}
+
+func (i *inspector) close() {
+	if i.clamd != nil {
+		i.clamd.Close()
+		i.clamd = nil
+	}
+}
+
+// hasZipDirectory reports whether a file's last bytes end with a ZIP end of central directory record
+func hasZipDirectory(tail []byte) bool {
+	for at := bytes.LastIndex(tail, []byte("PK\x05\x06")); at >= 0; at = bytes.LastIndex(tail[:at], []byte("PK\x05\x06")) {
+		// The record is 22 bytes followed by a comment that runs to the end of the file
+		if at+22 <= len(tail) && at+22+int(binary.LittleEndian.Uint16(tail[at+20:])) == len(tail) {
+			return true
+		}
+	}
+	return false
+}
+
+// blocked reports whether a SHA-256 is on the blocklist, which is reloaded whenever it changes
+func blocked(sha string) bool {
+	path := utils.AppConfig.Upload.Filter.Blocklist
+	if path == "" {
+		path = defaultBlocklist
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read upload blocklist: %v", err)
+		}
+		return false
+	}
+
+	blocklist.Lock()
+	defer blocklist.Unlock()
+	if path != blocklist.path || !info.ModTime().Equal(blocklist.modTime) {
+		loadBlocklistLocked(path, info.ModTime())
+	}
+	return blocklist.hashes[strings.ToLower(sha)]
+}
+
+// loadBlocklistLocked reads one hex SHA-256 per line, blank lines and lines starting with # are skipped
+func loadBlocklistLocked(path string, modTime time.Time) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read upload blocklist: %v", err)
+		return
+	}
+
+	hashes := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		hash := strings.ToLower(fields[0])
+		if raw, err := hex.DecodeString(hash); err != nil || len(raw) != 32 {
+			log.Printf("Skipping invalid hash %q in %s", fields[0], path)
+			continue
+		}
+		hashes[hash] = true
+	}
+	blocklist.path = path
+	blocklist.modTime = modTime
+	blocklist.hashes = hashes
+}
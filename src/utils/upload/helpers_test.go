@@ -0,0 +1,59 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"goFrame/src/utils"
+)
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// testFile is an upload of data as Receive would hand it to a backend
+func testFile(name, contentType string, data []byte) File {
+	return File{
+		Name:        name,
+		ContentType: contentType,
+		Size:        -1,
+		Body:        bytes.NewReader(data),
+		digest:      func() string { return sha256Hex(data) },
+	}
+}
+
+// useUploadConfig restores the upload settings a test changes once it is done
+func useUploadConfig(t *testing.T) *utils.UploadConfig {
+	saved := utils.AppConfig.Upload
+	t.Cleanup(func() { utils.AppConfig.Upload = saved })
+	return &utils.AppConfig.Upload
+}
+
+// standIn is the part every fake storage server shares: the HTTP server and the files it holds
+type standIn struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// start serves handler until the test ends
+func (s *standIn) start(t *testing.T, handler http.HandlerFunc) {
+	s.files = make(map[string][]byte)
+	s.Server = httptest.NewServer(handler)
+	t.Cleanup(s.Close)
+}
+
+// file returns what the server stores under key
+func (s *standIn) file(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	return data, ok
+}
@@ -0,0 +1,73 @@
+package upload
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"goFrame/src/utils"
+)
+
+// LocalURLPrefix is where files stored on this server are served from
+const LocalURLPrefix = "/uploads/"
+
+type localBackend struct {
+	dir string
+}
+
+func newLocalBackend(cfg utils.LocalUploadConfig) *localBackend {
+	if cfg.Dir == "" {
+		cfg.Dir = "data/uploads"
+	}
+	return &localBackend{dir: cfg.Dir}
+}
+
+// LocalDir is the directory the local backend stores files in
+func LocalDir() string {
+	return newLocalBackend(utils.AppConfig.Upload.Local).dir
+}
+
+func (b *localBackend) Name() string { return "local" }
+
+func (b *localBackend) Upload(ctx context.Context, f File) (Stored, error) {
+	if err := os.MkdirAll(b.dir, os.ModePerm); err != nil {
+		return Stored{}, err
+	}
+
+	// The name comes from the hash, so it is only known once the file has been written
+	tmp, err := os.CreateTemp(b.dir, ".upload-*")
+	if err != nil {
+		return Stored{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, f.Body); err != nil {
+		tmp.Close()
+		return Stored{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Stored{}, err
+	}
+
+	// Identical content is already stored under the same name
+	name := objectName(f)
+	target := filepath.Join(b.dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return Stored{URL: LocalURLPrefix + name}, nil
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return Stored{}, err
+	}
+	return Stored{URL: LocalURLPrefix + name}, nil
+}
+
+// Delete removes a stored file, a file that is already gone counts as deleted
+func (b *localBackend) Delete(ctx context.Context, r Record) error {
+	err := os.Remove(filepath.Join(b.dir, path.Base(r.URL)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
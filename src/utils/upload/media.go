@@ -0,0 +1,449 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registered for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+)
+
+const (
+	maxImagePixels = 50_000_000 // Larger images are stored without a thumbnail, decoding would use too much memory
+	thumbMaxSide   = 480
+	thumbQuality   = 80
+	blurhashSide   = 32 // Blurhashes are computed from a tiny copy, more detail is invisible anyway
+)
+
+// errNotImage is returned for formats the metadata stripper doesn't understand
+var errNotImage = errors.New("unsupported image format")
+
+// imageInfo is what processing learned about an image upload
+type imageInfo struct {
+	Width, Height int    // As displayed, after orientation
+	Thumb         []byte // JPEG, nil when the image couldn't be decoded
+	Blurhash      string
+}
+
+// processableImage reports whether uploads of this sniffed type have their metadata stripped
+func processableImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// stripImageMetadata copies an image without its EXIF, XMP, IPTC and text metadata and
+// without re-encoding it. JPEG orientation is kept so photos still display upright.
+func stripImageMetadata(dst io.WriteSeeker, src io.Reader, contentType string) (orientation int, err error) {
+	r := bufio.NewReader(src)
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(dst, r)
+	case "image/png":
+		return 1, stripPNG(dst, r)
+	case "image/webp":
+		return 1, stripWebP(dst, r)
+	case "image/gif":
+		// GIFs carry no location data worth stripping
+		_, err := io.Copy(dst, r)
+		return 1, err
+	}
+	return 0, errNotImage
+}
+
+// describeImage measures an image and renders its thumbnail and blurhash
+func describeImage(path, contentType string, orientation int) (imageInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	defer file.Close()
+
+	var info imageInfo
+	if contentType == "image/webp" {
+		// The standard library can't decode WebP, the header still has its size
+		info.Width, info.Height, err = webpDimensions(file)
+		return info, err
+	}
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return imageInfo{}, err
+	}
+	info.Width, info.Height = config.Width, config.Height
+	if orientation >= 5 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return info, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return info, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return info, err
+	}
+
+	thumb := orient(resize(img, thumbMaxSide), orientation)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbQuality}); err != nil {
+		return info, err
+	}
+	info.Thumb = buf.Bytes()
+	info.Blurhash = blurhash(resize(thumb, blurhashSide))
+	return info, nil
+}
+
+// stripJPEG drops APP1 (EXIF, XMP), APP3-APP13, APP15 and comment segments. APP0 (JFIF),
+// APP2 (ICC profile) and APP14 (Adobe colour transform) affect how the image looks and stay.
+func stripJPEG(dst io.Writer, r *bufio.Reader) (int, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return 0, fmt.Errorf("not a JPEG")
+	}
+	if _, err := dst.Write(soi[:]); err != nil {
+		return 0, err
+	}
+
+	orientation := 1
+	wroteOrientation := false
+	for {
+		marker, err := nextJPEGMarker(r)
+		if err != nil {
+			return 0, err
+		}
+
+		// Orientation is put back once every metadata segment has been seen
+		isApp := marker >= 0xE0 && marker <= 0xEF || marker == 0xFE
+		if !isApp && !wroteOrientation {
+			wroteOrientation = true
+			if orientation > 1 {
+				if _, err := dst.Write(orientationSegment(orientation)); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		// Standalone markers have no length
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			if _, err := dst.Write([]byte{0xFF, marker}); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return 0, err
+		}
+		size := int64(binary.BigEndian.Uint16(length[:])) - 2
+		if size < 0 {
+			return 0, fmt.Errorf("invalid JPEG segment length")
+		}
+
+		keep := !isApp || marker == 0xE0 || marker == 0xE2 || marker == 0xEE
+		if !keep {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, err
+			}
+			if marker == 0xE1 {
+				if o := exifOrientation(data); o > 0 {
+					orientation = o
+				}
+			}
+			continue
+		}
+
+		if _, err := dst.Write([]byte{0xFF, marker, length[0], length[1]}); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return 0, err
+		}
+
+		// Everything after the start of scan is image data
+		if marker == 0xDA {
+			_, err := io.Copy(dst, r)
+			return orientation, err
+		}
+	}
+}
+
+func nextJPEGMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid JPEG marker")
+	}
+	// Markers may be padded with any number of 0xFF
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// exifOrientation reads the orientation tag from an APP1 EXIF segment, 0 if it has none
+func exifOrientation(data []byte) int {
+	tiff, ok := bytes.CutPrefix(data, []byte("Exif\x00\x00"))
+	if !ok || len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int64(order.Uint32(tiff[4:8]))
+	if ifd+2 > int64(len(tiff)) {
+		return 0
+	}
+	entries := int64(order.Uint16(tiff[ifd:]))
+	for i := int64(0); i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment is an APP1 EXIF segment holding nothing but the orientation tag
+func orientationSegment(orientation int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xE1, 0x00, 34})
+	b.WriteString("Exif\x00\x00")
+	b.Write([]byte{'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08}) // Big endian TIFF header, IFD0 at 8
+	b.Write([]byte{0x00, 0x01})                                   // One entry
+	b.Write([]byte{0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00})
+	b.Write([]byte{0x00, 0x00, 0x00, 0x00}) // No next IFD
+	return b.Bytes()
+}
+
+// stripPNG drops the text, EXIF and timestamp chunks
+func stripPNG(dst io.Writer, r *bufio.Reader) error {
+	signature := make([]byte, 8)
+	if _, err := io.ReadFull(r, signature); err != nil || string(signature) != "\x89PNG\r\n\x1a\n" {
+		return fmt.Errorf("not a PNG")
+	}
+	if _, err := dst.Write(signature); err != nil {
+		return err
+	}
+
+	for {
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4])) + 4 // Data and CRC
+
+		switch string(header[4:]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := dst.Write(header); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return err
+		}
+		if string(header[4:]) == "IEND" {
+			return nil
+		}
+	}
+}
+
+// stripWebP drops the EXIF and XMP chunks, clears their flags and fixes up the RIFF size
+func stripWebP(dst io.WriteSeeker, r *bufio.Reader) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return fmt.Errorf("not a WebP")
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	written := int64(4) // "WEBP"
+	for {
+		chunk := make([]byte, 8)
+		if _, err := io.ReadFull(r, chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		padded := size + size%2
+
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return err
+			}
+			continue
+		case "VP8X":
+			// The chunk has a fixed size, anything else is a broken or hostile file
+			if size != 10 {
+				return fmt.Errorf("%w: VP8X chunk of %d bytes", ErrInvalidImage, size)
+			}
+			data := make([]byte, padded)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return err
+			}
+			data[0] &^= 0x08 | 0x04 // EXIF and XMP present
+			if _, err := dst.Write(append(chunk, data...)); err != nil {
+				return err
+			}
+			written += 8 + padded
+			continue
+		}
+
+		if _, err := dst.Write(chunk); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(dst, r, padded); err != nil {
+			return err
+		}
+		written += 8 + padded
+	}
+
+	if _, err := dst.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(written))
+	if _, err := dst.Write(size); err != nil {
+		return err
+	}
+	_, err := dst.Seek(0, io.SeekEnd)
+	return err
+}
+
+// webpDimensions reads the canvas size from a WebP's first chunk
+func webpDimensions(r io.Reader) (int, int, error) {
+	header := make([]byte, 30)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, err
+	}
+	le24 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 }
+
+	switch string(header[12:16]) {
+	case "VP8X":
+		return le24(header[24:27]) + 1, le24(header[27:30]) + 1, nil
+	case "VP8 ":
+		return int(binary.LittleEndian.Uint16(header[26:28]) & 0x3FFF), int(binary.LittleEndian.Uint16(header[28:30]) & 0x3FFF), nil
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(header[21:25])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1, nil
+	}
+	return 0, 0, errNotImage
+}
+
+// resize scales an image down so its longest side is at most maxSide, averaging the
+// source pixels under each destination pixel
+func resize(src image.Image, maxSide int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			w, h = maxSide, max(1, h*maxSide/w)
+		} else {
+			w, h = max(1, w*maxSide/h), maxSide
+		}
+	}
+
+	// Sampling a fixed grid per pixel keeps big images fast without copying them
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(src.At(x, y)).(color.RGBA)
+	}
+	if rgba, ok := src.(*image.RGBA); ok {
+		at = rgba.RGBAAt
+	}
+
+	const samples = 4
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					px := bounds.Min.X + (x*samples+sx)*bounds.Dx()/(w*samples)
+					py := bounds.Min.Y + (y*samples+sy)*bounds.Dy()/(h*samples)
+					c := at(px, py)
+					r, g, b, a = r+uint32(c.R), g+uint32(c.G), b+uint32(c.B), a+uint32(c.A)
+				}
+			}
+			n := uint32(samples * samples)
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation so the image is upright
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // Rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // Transposed
+				dx, dy = y, x
+			case 6: // Rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // Transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // Rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
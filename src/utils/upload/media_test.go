@@ -0,0 +1,259 @@
+package upload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cat joins byte slices and strings into one file
+func cat(parts ...any) []byte {
+	var b bytes.Buffer
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			b.WriteString(p)
+		case []byte:
+			b.Write(p)
+		}
+	}
+	return b.Bytes()
+}
+
+func be16(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+func be32(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+func le32(v int) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(v)) }
+
+// exifSegment is an APP1 payload with one IFD entry in big endian TIFF
+func exifSegment(tag, orientation int) []byte {
+	return cat("Exif\x00\x00", "MM\x00\x2A", be32(8), be16(1), be16(tag), be16(3), be32(1), be16(orientation), be16(0), be32(0))
+}
+
+func jpegSegment(marker byte, data []byte) []byte {
+	return cat([]byte{0xFF, marker}, be16(len(data)+2), data)
+}
+
+func TestStripJPEG(t *testing.T) {
+	soi := []byte{0xFF, 0xD8}
+	scan := cat(jpegSegment(0xDA, []byte{1, 2, 3}), "image data")
+
+	tests := []struct {
+		name        string
+		in          []byte
+		want        []byte // nil when stripping has to fail
+		orientation int
+	}{
+		{
+			name:        "EXIF and comment dropped, orientation kept",
+			in:          cat(soi, jpegSegment(0xE0, []byte("JFIF\x00")), jpegSegment(0xE1, exifSegment(0x0112, 6)), jpegSegment(0xFE, []byte("secret")), scan),
+			want:        cat(soi, jpegSegment(0xE0, []byte("JFIF\x00")), orientationSegment(6), scan),
+			orientation: 6,
+		},
+		{
+			name:        "upright photo gets no orientation segment",
+			in:          cat(soi, jpegSegment(0xE1, exifSegment(0x0112, 1)), scan),
+			want:        cat(soi, scan),
+			orientation: 1,
+		},
+		{name: "empty", in: nil},
+		{name: "not a JPEG", in: []byte("GIF89a")},
+		{name: "truncated after SOI", in: soi},
+		{name: "junk instead of a marker", in: cat(soi, "\x00\x00")},
+		{name: "truncated segment length", in: cat(soi, []byte{0xFF, 0xE1, 0x00})},
+		{name: "segment length below 2", in: cat(soi, []byte{0xFF, 0xE1, 0x00, 0x01})},
+		{name: "segment longer than the file", in: cat(soi, []byte{0xFF, 0xE1, 0xFF, 0xFF}, "Exif")},
+		{name: "kept segment longer than the file", in: cat(soi, []byte{0xFF, 0xDB, 0xFF, 0xFF}, "table")},
+		{name: "ends before the scan", in: cat(soi, jpegSegment(0xE0, []byte("JFIF\x00")))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			orientation, err := stripJPEG(&out, bufio.NewReader(bytes.NewReader(tt.in)))
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("stripped %q without an error", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stripJPEG: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.want) {
+				t.Errorf("stripped to\n%q\nwant\n%q", out.Bytes(), tt.want)
+			}
+			if orientation != tt.orientation {
+				t.Errorf("orientation = %d, want %d", orientation, tt.orientation)
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	little := cat("Exif\x00\x00", "II\x2A\x00", []byte{8, 0, 0, 0}, []byte{1, 0}, []byte{0x12, 0x01, 3, 0, 1, 0, 0, 0, 8, 0, 0, 0}, []byte{0, 0, 0, 0})
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"big endian", exifSegment(0x0112, 6), 6},
+		{"little endian", little, 8},
+		{"orientation after another tag", cat("Exif\x00\x00", "MM\x00\x2A", be32(8), be16(2), be16(0x010F), be16(2), be32(4), be32(0), be16(0x0112), be16(3), be32(1), be16(3), be16(0)), 3},
+		{"no orientation tag", exifSegment(0x010F, 6), 0},
+		{"orientation out of range", exifSegment(0x0112, 9), 0},
+		{"orientation zero", exifSegment(0x0112, 0), 0},
+		{"empty", nil, 0},
+		{"XMP instead of EXIF", []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"), 0},
+		{"truncated TIFF header", []byte("Exif\x00\x00MM\x00"), 0},
+		{"unknown byte order", cat("Exif\x00\x00", "XX\x00\x2A", be32(8), be16(0)), 0},
+		{"IFD past the end", cat("Exif\x00\x00", "MM\x00\x2A", be32(1000), be16(1)), 0},
+		{"IFD offset near 4 GiB", cat("Exif\x00\x00", "MM\x00\x2A", be32(0xFFFFFFFF), be16(1)), 0},
+		{"entries past the end", cat("Exif\x00\x00", "MM\x00\x2A", be32(8), be16(0xFFFF), be16(0x0112), be16(3)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.data); got != tt.want {
+				t.Errorf("exifOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	return cat(be32(len(data)), kind, data, "CRC!")
+}
+
+func TestStripPNG(t *testing.T) {
+	signature := "\x89PNG\r\n\x1a\n"
+	ihdr := pngChunk("IHDR", make([]byte, 13))
+	idat := pngChunk("IDAT", []byte("pixels"))
+	iend := pngChunk("IEND", nil)
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte // nil when stripping has to fail
+	}{
+		{
+			name: "text, EXIF and time dropped",
+			in:   cat(signature, ihdr, pngChunk("tEXt", []byte("Author\x00me")), pngChunk("eXIf", exifSegment(0x0112, 6)[6:]), pngChunk("tIME", make([]byte, 7)), idat, iend),
+			want: cat(signature, ihdr, idat, iend),
+		},
+		{
+			name: "anything after IEND is left out",
+			in:   cat(signature, ihdr, idat, iend, "trailing"),
+			want: cat(signature, ihdr, idat, iend),
+		},
+		{name: "empty", in: nil},
+		{name: "not a PNG", in: []byte("\x89PNX\r\n\x1a\n")},
+		{name: "truncated signature", in: []byte("\x89PN")},
+		{name: "truncated chunk header", in: cat(signature, ihdr, "\x00\x00")},
+		{name: "chunk longer than the file", in: cat(signature, be32(0x7FFFFFFF), "IDAT", "short")},
+		{name: "dropped chunk longer than the file", in: cat(signature, be32(0xFFFFFFFF), "tEXt", "short")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := stripPNG(&out, bufio.NewReader(bytes.NewReader(tt.in)))
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("stripped %q without an error", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stripPNG: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), tt.want) {
+				t.Errorf("stripped to\n%q\nwant\n%q", out.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func webpChunk(kind string, data []byte) []byte {
+	padded := data
+	if len(data)%2 == 1 {
+		padded = cat(data, "\x00")
+	}
+	return cat(kind, le32(len(data)), padded)
+}
+
+// webpFile wraps chunks in a RIFF header with the right size
+func webpFile(chunks ...[]byte) []byte {
+	body := cat("WEBP", bytes.Join(chunks, nil))
+	return cat("RIFF", le32(len(body)), body)
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := func(flags byte) []byte {
+		return webpChunk("VP8X", []byte{flags, 0, 0, 0, 99, 0, 0, 99, 0, 0})
+	}
+	vp8l := webpChunk("VP8L", []byte("lossless"))
+	exif := webpChunk("EXIF", exifSegment(0x0112, 6)[6:])
+	xmp := webpChunk("XMP ", []byte("<x:xmpmeta/>"))
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte // nil when stripping has to fail
+	}{
+		{
+			name: "EXIF and XMP dropped, flags cleared",
+			in:   webpFile(vp8x(0x2C), vp8l, exif, xmp),
+			want: webpFile(vp8x(0x20), vp8l),
+		},
+		{
+			name: "simple file untouched",
+			in:   webpFile(webpChunk("VP8 ", []byte("lossy!"))),
+			want: webpFile(webpChunk("VP8 ", []byte("lossy!"))),
+		},
+		{
+			name: "odd chunk keeps its padding",
+			in:   webpFile(webpChunk("VP8L", []byte("odd"))),
+			want: webpFile(webpChunk("VP8L", []byte("odd"))),
+		},
+		{name: "empty", in: nil},
+		{name: "not RIFF", in: cat("RIFX", le32(4), "WEBP")},
+		{name: "RIFF but not WebP", in: cat("RIFF", le32(4), "WAVE")},
+		{name: "VP8X of 0 bytes", in: cat("RIFF", le32(12), "WEBPVP8X\x00\x00\x00\x00")},
+		{name: "VP8X of 4 GiB", in: cat("RIFF", le32(12), "WEBPVP8X", le32(0xFFFFFFFE))},
+		{name: "VP8X longer than its fixed size", in: webpFile(webpChunk("VP8X", make([]byte, 12)))},
+		{name: "truncated VP8X", in: cat("RIFF", le32(16), "WEBPVP8X", le32(10), "\x00\x00")},
+		{name: "truncated chunk header", in: cat(webpFile(vp8l), "VP8")},
+		{name: "chunk longer than the file", in: cat("RIFF", le32(12), "WEBPVP8L", le32(0x7FFFFFFF), "short")},
+		{name: "dropped chunk longer than the file", in: cat(webpFile(vp8x(0x08), vp8l), "EXIF", le32(0xFFFFFFFF), "short")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := os.Create(filepath.Join(t.TempDir(), "out.webp"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer out.Close()
+
+			err = stripWebP(out, bufio.NewReader(bytes.NewReader(tt.in)))
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("stripped %q without an error", tt.in)
+				}
+				if strings.Contains(tt.name, "VP8X of") && !errors.Is(err, ErrInvalidImage) {
+					t.Errorf("error = %v, want ErrInvalidImage", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("stripWebP: %v", err)
+			}
+			got, _ := os.ReadFile(out.Name())
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripped to\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package upload
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goFrame/src/lightning"
+	"goFrame/src/utils"
+)
+
+var quotesFile = "data/upload-quotes.json"
+
+// paidQuoteLifetime is how long a paid quote can still be redeemed for an upload
+const paidQuoteLifetime = 30 * 24 * time.Hour
+
+var (
+	// ErrPaymentRequired is returned when an upload needs a paid quote it doesn't have
+	ErrPaymentRequired = errors.New("payment required")
+	// ErrQuoteUsed is returned when a paid quote has already covered an upload
+	ErrQuoteUsed = errors.New("quote already used")
+	// ErrNoPrice is returned when no pricing tier covers the requested size and retention
+	ErrNoPrice = errors.New("no pricing tier covers this upload")
+)
+
+// Quote is the price of one upload and the invoice that pays for it. The payment hash,
+// or the preimage once the invoice is paid, is the token the upload is made with.
+type Quote struct {
+	PaymentHash string `json:"payment_hash,omitempty"`
+	Bolt11      string `json:"bolt11,omitempty"`
+	Label       string `json:"-"`
+	Size        int64  `json:"size"` // Largest file the quote covers, in bytes
+	Days        int    `json:"days"` // How long the file is kept
+	Sats        int64  `json:"sats"`
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"` // When the invoice expires
+	RedeemBy    int64  `json:"redeem_by,omitempty"`  // When a paid quote is forgotten if it hasn't been used
+	Paid        bool   `json:"paid"`
+	Used        bool   `json:"used"` // An upload was stored with it
+}
+
+// storedQuote keeps the invoice label on disk, it is left out of API responses
+type storedQuote struct {
+	Quote
+	Label string `json:"label"`
+}
+
+var quotes = struct {
+	sync.Mutex
+	byHash  map[string]*Quote
+	claimed map[string]bool // Quotes with an upload in progress, a restart frees them
+}{claimed: make(map[string]bool)}
+
+// PaymentsEnabled reports whether uploads over the free size have to be paid for
+func PaymentsEnabled() bool {
+	return utils.AppConfig.Upload.Payment.Enabled
+}
+
+// FreeMaxSize is the largest upload accepted without payment, in bytes
+func FreeMaxSize() int64 {
+	if !PaymentsEnabled() {
+		return MaxSize()
+	}
+	return min(utils.AppConfig.Upload.Payment.FreeMaxMB<<20, MaxSize())
+}
+
+// PriceTiers returns the configured tiers, cheapest first
+func PriceTiers() []utils.PriceTier {
+	tiers := append([]utils.PriceTier{}, utils.AppConfig.Upload.Payment.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Sats < tiers[j].Sats })
+	return tiers
+}
+
+// Price picks the cheapest tier that covers a file of the given size for the given number of days
+func Price(size int64, days int) (utils.PriceTier, error) {
+	for _, tier := range PriceTiers() {
+		if size <= tier.MaxMB<<20 && days <= tier.Days {
+			return tier, nil
+		}
+	}
+	return utils.PriceTier{}, ErrNoPrice
+}
+
+// CreateQuote prices an upload and, unless it is free, requests an invoice for it
+func CreateQuote(size int64, days int) (Quote, error) {
+	if size <= 0 || days < 0 {
+		return Quote{}, fmt.Errorf("%w: size and days must be positive", ErrNoPrice)
+	}
+	if size > MaxSize() {
+		return Quote{}, ErrTooLarge
+	}
+
+	quote := Quote{Size: size, Days: days, CreatedAt: time.Now().Unix()}
+	if size <= FreeMaxSize() {
+		return quote, nil
+	}
+
+	tier, err := Price(size, days)
+	if err != nil {
+		return Quote{}, err
+	}
+	// The quote covers everything the tier allows, not just the requested size
+	quote.Size = min(tier.MaxMB<<20, MaxSize())
+	quote.Days = tier.Days
+	quote.Sats = tier.Sats
+
+	description := fmt.Sprintf("File upload up to %d MB kept for %d days", quote.Size>>20, quote.Days)
+	invoice, err := lightning.FetchInvoiceWithLabel(tier.Sats*1000, description)
+	if err != nil {
+		return Quote{}, err
+	}
+	if invoice.Bolt11 == "" || invoice.PaymentHash == "" {
+		return Quote{}, fmt.Errorf("the Lightning node returned no invoice")
+	}
+	quote.Bolt11 = invoice.Bolt11
+	quote.PaymentHash = invoice.PaymentHash
+	quote.Label = invoice.Label
+	quote.ExpiresAt = invoice.ExpiresAt
+
+	quotes.Lock()
+	defer quotes.Unlock()
+	loadQuotesLocked()
+	quotes.byHash[quote.PaymentHash] = &quote
+	if err := saveQuotesLocked(); err != nil {
+		return Quote{}, err
+	}
+	return quote, nil
+}
+
+// QuoteStatus returns a quote, asking the Lightning node whether it has been paid yet
+func QuoteStatus(paymentHash string) (Quote, error) {
+	quotes.Lock()
+	defer quotes.Unlock()
+	loadQuotesLocked()
+
+	quote, ok := quotes.byHash[strings.ToLower(paymentHash)]
+	if !ok {
+		return Quote{}, os.ErrNotExist
+	}
+	if err := checkPaidLocked(quote); err != nil {
+		return Quote{}, err
+	}
+	return *quote, nil
+}
+
+// ClaimQuote reserves a paid quote for one upload. The token is the invoice's payment
+// hash or preimage. Call RedeemQuote once the upload is stored, or ReleaseQuote if it
+// fails so the payment isn't lost.
+func ClaimQuote(token string) (Quote, error) {
+	token = strings.ToLower(strings.TrimSpace(token))
+	raw, err := hex.DecodeString(token)
+	if err != nil || len(raw) != sha256.Size {
+		return Quote{}, fmt.Errorf("%w: invalid payment token", ErrPaymentRequired)
+	}
+
+	quotes.Lock()
+	defer quotes.Unlock()
+	loadQuotesLocked()
+
+	// A preimage proves payment by itself, only the payer learns it
+	quote, ok := quotes.byHash[token]
+	if !ok {
+		sum := sha256.Sum256(raw)
+		if quote, ok = quotes.byHash[hex.EncodeToString(sum[:])]; ok {
+			markPaidLocked(quote)
+		}
+	}
+	if !ok {
+		return Quote{}, fmt.Errorf("%w: unknown payment token", ErrPaymentRequired)
+	}
+	if quote.Used || quotes.claimed[quote.PaymentHash] {
+		return Quote{}, ErrQuoteUsed
+	}
+	if err := checkPaidLocked(quote); err != nil {
+		return Quote{}, err
+	}
+	if !quote.Paid {
+		return Quote{}, fmt.Errorf("%w: the invoice hasn't been paid", ErrPaymentRequired)
+	}
+
+	if err := saveQuotesLocked(); err != nil {
+		return Quote{}, err
+	}
+	quotes.claimed[quote.PaymentHash] = true
+	return *quote, nil
+}
+
+// RedeemQuote uses up a claimed quote once its upload has been stored
+func RedeemQuote(paymentHash string) error {
+	quotes.Lock()
+	defer quotes.Unlock()
+	delete(quotes.claimed, paymentHash)
+	quote, ok := quotes.byHash[paymentHash]
+	if !ok {
+		return os.ErrNotExist
+	}
+	quote.Used = true
+	return saveQuotesLocked()
+}
+
+// ReleaseQuote makes a claimed quote available again after a failed upload
+func ReleaseQuote(paymentHash string) {
+	quotes.Lock()
+	defer quotes.Unlock()
+	delete(quotes.claimed, paymentHash)
+}
+
+func checkPaidLocked(quote *Quote) error {
+	if quote.Paid {
+		return nil
+	}
+	invoice, err := lightning.LookupInvoice(quote.Label)
+	if err != nil {
+		return fmt.Errorf("check invoice: %w", err)
+	}
+	if invoice.Status == "paid" {
+		markPaidLocked(quote)
+		return saveQuotesLocked()
+	}
+	return nil
+}
+
+// markPaidLocked starts the time a paid quote has left to be redeemed
+func markPaidLocked(quote *Quote) {
+	quote.Paid = true
+	if quote.RedeemBy == 0 {
+		quote.RedeemBy = time.Now().Add(paidQuoteLifetime).Unix()
+	}
+}
+
+func loadQuotesLocked() {
+	if quotes.byHash != nil {
+		return
+	}
+	quotes.byHash = make(map[string]*Quote)
+
+	data, err := os.ReadFile(quotesFile)
+	if err != nil {
+		return
+	}
+	var stored []storedQuote
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return
+	}
+	for _, s := range stored {
+		quote := s.Quote
+		quote.Label = s.Label
+		// Quotes paid before redemption deadlines existed get theirs from when they were made
+		if quote.Paid && quote.RedeemBy == 0 {
+			quote.RedeemBy = time.Unix(quote.CreatedAt, 0).Add(paidQuoteLifetime).Unix()
+		}
+		quotes.byHash[quote.PaymentHash] = &quote
+	}
+}
+
+// saveQuotesLocked writes the quotes to disk, dropping those that can no longer be used.
+// Unpaid quotes go when their invoice expires. Paid ones stay until their redemption
+// deadline, however long ago the invoice expired, so a failed upload can be retried.
+func saveQuotesLocked() error {
+	now := time.Now().Unix()
+	stored := []storedQuote{}
+	for hash, quote := range quotes.byHash {
+		expired := quote.ExpiresAt < now
+		if quote.Paid {
+			expired = quote.RedeemBy < now && !quotes.claimed[hash]
+		}
+		if expired {
+			delete(quotes.byHash, hash)
+			continue
+		}
+		stored = append(stored, storedQuote{Quote: *quote, Label: quote.Label})
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(quotesFile), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(quotesFile, data, 0644)
+}
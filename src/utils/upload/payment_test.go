@@ -0,0 +1,133 @@
+package upload
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// useQuotes starts the quote store afresh in a temporary directory with the given quotes
+func useQuotes(t *testing.T, seed ...Quote) {
+	t.Cleanup(UseDataDir(t.TempDir()))
+
+	quotes.Lock()
+	defer quotes.Unlock()
+	quotes.byHash = make(map[string]*Quote)
+	for _, quote := range seed {
+		quotes.byHash[quote.PaymentHash] = &quote
+	}
+	if err := saveQuotesLocked(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloadQuotes forgets the quotes in memory as a restart would
+func reloadQuotes() {
+	quotes.Lock()
+	defer quotes.Unlock()
+	quotes.byHash = nil
+	clear(quotes.claimed)
+}
+
+func TestClaimAfterInvoiceExpiry(t *testing.T) {
+	preimage := []byte("0123456789abcdef0123456789abcdef")
+	token := hex.EncodeToString(preimage)
+	hash := sha256Hex(preimage)
+
+	// Paid a while ago, the invoice has expired since
+	now := time.Now()
+	useQuotes(t, Quote{
+		PaymentHash: hash,
+		Size:        100 << 20,
+		Days:        30,
+		CreatedAt:   now.Add(-2 * time.Hour).Unix(),
+		ExpiresAt:   now.Add(-time.Hour).Unix(),
+		RedeemBy:    now.Add(time.Hour).Unix(),
+		Paid:        true,
+	})
+
+	quote, err := ClaimQuote(token)
+	if err != nil {
+		t.Fatalf("claim after the invoice expired: %v", err)
+	}
+	if quote.PaymentHash != hash || quote.Size != 100<<20 {
+		t.Errorf("claimed %+v", quote)
+	}
+	if _, err := ClaimQuote(token); !errors.Is(err, ErrQuoteUsed) {
+		t.Errorf("second claim while uploading = %v, want ErrQuoteUsed", err)
+	}
+
+	// The upload fails, so the payment is given back and survives a restart
+	ReleaseQuote(hash)
+	reloadQuotes()
+	if _, err := ClaimQuote(token); err != nil {
+		t.Fatalf("claim after release: %v", err)
+	}
+
+	// This time the upload is stored
+	if err := RedeemQuote(hash); err != nil {
+		t.Fatalf("RedeemQuote: %v", err)
+	}
+	if _, err := ClaimQuote(token); !errors.Is(err, ErrQuoteUsed) {
+		t.Errorf("claim after redeeming = %v, want ErrQuoteUsed", err)
+	}
+	reloadQuotes()
+	if _, err := ClaimQuote(hash); !errors.Is(err, ErrQuoteUsed) {
+		t.Errorf("claim after redeeming and restarting = %v, want ErrQuoteUsed", err)
+	}
+}
+
+func TestQuotePruning(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour).Unix(), now.Add(time.Hour).Unix()
+	useQuotes(t,
+		Quote{PaymentHash: "unpaid-expired", ExpiresAt: past},
+		Quote{PaymentHash: "unpaid-open", ExpiresAt: future},
+		Quote{PaymentHash: "paid-expired-invoice", ExpiresAt: past, RedeemBy: future, Paid: true},
+		Quote{PaymentHash: "paid-past-deadline", ExpiresAt: past, RedeemBy: past, Paid: true},
+		Quote{PaymentHash: "used-expired-invoice", ExpiresAt: past, RedeemBy: future, Paid: true, Used: true},
+	)
+	reloadQuotes()
+
+	quotes.Lock()
+	defer quotes.Unlock()
+	loadQuotesLocked()
+	want := map[string]bool{
+		"unpaid-expired":       false,
+		"unpaid-open":          true,
+		"paid-expired-invoice": true,
+		"paid-past-deadline":   false,
+		"used-expired-invoice": true,
+	}
+	for hash, kept := range want {
+		if _, ok := quotes.byHash[hash]; ok != kept {
+			t.Errorf("%s kept = %v, want %v", hash, ok, kept)
+		}
+	}
+}
+
+func TestLegacyPaidQuoteDeadline(t *testing.T) {
+	useQuotes(t)
+	reloadQuotes()
+
+	// Written before paid quotes had a redemption deadline
+	created := time.Now().Add(-time.Hour)
+	legacy := fmt.Sprintf(`[{"payment_hash":"legacy","created_at":%d,"expires_at":%d,"paid":true,"used":false,"label":"l"}]`, created.Unix(), created.Unix())
+	if err := os.WriteFile(quotesFile, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	quotes.Lock()
+	defer quotes.Unlock()
+	loadQuotesLocked()
+	quote, ok := quotes.byHash["legacy"]
+	if !ok {
+		t.Fatal("paid quote without a deadline was dropped")
+	}
+	if want := created.Add(paidQuoteLifetime).Unix(); quote.RedeemBy != want {
+		t.Errorf("RedeemBy = %d, want %d", quote.RedeemBy, want)
+	}
+}
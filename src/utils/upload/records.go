@@ -0,0 +1,287 @@
+package upload
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goFrame/src/utils"
+)
+
+var recordsFile = "data/upload-records.json"
+
+// Record is a stored upload, kept so its owner can list and delete it and so it can expire
+type Record struct {
+	ID             string `json:"id"`
+	Owner          string `json:"-"` // See PubkeyOwner and BrowserOwner, empty for anonymous uploads
+	URL            string `json:"url"`
+	Name           string `json:"name"`
+	ContentType    string `json:"content_type"`
+	Size           int64  `json:"size"`
+	SHA256         string `json:"sha256"`
+	OriginalSHA256 string `json:"original_sha256,omitempty"` // Before metadata was stripped, for images
+	Backend        string `json:"backend"`
+	Token          string `json:"-"` // Management token from the backend, only used to delete the file
+	Dim            string `json:"dim,omitempty"`
+	Blurhash       string `json:"blurhash,omitempty"`
+	Thumb          string `json:"thumb,omitempty"`
+	ThumbSHA256    string `json:"thumb_sha256,omitempty"`
+	ThumbToken     string `json:"-"`
+	CreatedAt      int64  `json:"created_at"`
+	ExpiresAt      int64  `json:"expires_at,omitempty"`
+}
+
+// storedRecord keeps the owner and management tokens on disk, they are left out of API responses
+type storedRecord struct {
+	Record
+	Owner      string `json:"owner,omitempty"`
+	Token      string `json:"token,omitempty"`
+	ThumbToken string `json:"thumb_token,omitempty"`
+}
+
+var records = struct {
+	sync.Mutex
+	byID map[string]*Record
+}{}
+
+// UseDataDir keeps upload records and quotes in dir, forgetting the ones loaded from the
+// previous files, and returns a function that switches back. Tests use it to stay out of data/.
+func UseDataDir(dir string) (restore func()) {
+	previous := filepath.Dir(recordsFile)
+	switchDataDir(dir)
+	return func() { switchDataDir(previous) }
+}
+
+func switchDataDir(dir string) {
+	records.Lock()
+	defer records.Unlock()
+	quotes.Lock()
+	defer quotes.Unlock()
+
+	recordsFile = filepath.Join(dir, filepath.Base(recordsFile))
+	quotesFile = filepath.Join(dir, filepath.Base(quotesFile))
+	records.byID = nil
+	quotes.byHash = nil
+	clear(quotes.claimed)
+}
+
+// PubkeyOwner identifies an uploader who signed the request with NIP-98
+func PubkeyOwner(pubkey string) string {
+	return "pubkey:" + pubkey
+}
+
+// BrowserOwner identifies an uploader by a random token their browser keeps.
+// Only its hash is stored, so the records file can't be used to impersonate them.
+func BrowserOwner(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "browser:" + hex.EncodeToString(sum[:])
+}
+
+// Expiry is when a file kept for the given number of days expires, zero to keep it.
+// Without days the upload.expire_days setting applies.
+func Expiry(days int) time.Time {
+	if days <= 0 {
+		days = utils.AppConfig.Upload.ExpireDays
+	}
+	if days <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, days)
+}
+
+// AddRecord remembers a stored upload and returns it with its ID
+func AddRecord(r Record) (Record, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return Record{}, err
+	}
+	r.ID = hex.EncodeToString(id)
+	r.CreatedAt = time.Now().Unix()
+
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+	records.byID[r.ID] = &r
+	return r, saveRecordsLocked()
+}
+
+// ListRecords returns an owner's uploads, newest first
+func ListRecords(owner string) []Record {
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+
+	list := []Record{}
+	for _, r := range records.byID {
+		if owner != "" && r.Owner == owner {
+			list = append(list, *r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt > list[j].CreatedAt })
+	return list
+}
+
+// RecordByHash finds an upload by the SHA-256 of the stored file or of the file as it was
+// uploaded. Owners can be anyone, the newest match wins.
+func RecordByHash(sha string) (Record, error) {
+	sha = strings.ToLower(sha)
+
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+
+	var found *Record
+	for _, r := range records.byID {
+		if (r.SHA256 == sha || r.OriginalSHA256 == sha) && (found == nil || r.CreatedAt > found.CreatedAt) {
+			found = r
+		}
+	}
+	if found == nil {
+		return Record{}, os.ErrNotExist
+	}
+	return *found, nil
+}
+
+// DeleteRecord deletes one of the owner's uploads from its backend and forgets it
+func DeleteRecord(ctx context.Context, owner, id string) error {
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+
+	r, ok := records.byID[id]
+	if !ok || owner == "" || r.Owner != owner {
+		return os.ErrNotExist
+	}
+	if err := deleteStoredLocked(ctx, *r); err != nil {
+		return err
+	}
+	delete(records.byID, id)
+	return saveRecordsLocked()
+}
+
+// DeleteExpired deletes uploads past their expiry. Files that fail to delete are retried next time.
+func DeleteExpired() {
+	now := time.Now().Unix()
+
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+
+	var expired []Record
+	for _, r := range records.byID {
+		if r.ExpiresAt > 0 && r.ExpiresAt <= now {
+			expired = append(expired, *r)
+		}
+	}
+
+	for _, r := range expired {
+		if err := deleteStoredLocked(context.Background(), r); err != nil {
+			log.Printf("Failed to delete expired upload %s: %v", r.URL, err)
+			continue
+		}
+		delete(records.byID, r.ID)
+		if err := saveRecordsLocked(); err != nil {
+			log.Printf("Failed to save upload records: %v", err)
+		}
+		log.Printf("Deleted expired upload %s", r.URL)
+	}
+}
+
+// Discard deletes a stored upload that is not going to be recorded, files that recorded
+// uploads share are left alone
+func Discard(ctx context.Context, r Record) error {
+	records.Lock()
+	defer records.Unlock()
+	loadRecordsLocked()
+	return deleteStoredLocked(ctx, r)
+}
+
+// deleteStoredLocked removes a file and its thumbnail from the backend. The records lock
+// is held throughout, so an upload of the same content can't be recorded in between.
+func deleteStoredLocked(ctx context.Context, r Record) error {
+	if err := deleteStoredFileLocked(ctx, r); err != nil {
+		return err
+	}
+	if r.Thumb == "" {
+		return nil
+	}
+	return deleteStoredFileLocked(ctx, Record{
+		ID:      r.ID,
+		URL:     r.Thumb,
+		SHA256:  r.ThumbSHA256,
+		Backend: r.Backend,
+		Token:   r.ThumbToken,
+	})
+}
+
+// deleteStoredFileLocked removes a file from its backend unless another record still uses
+// it, content-addressed backends store identical uploads only once
+func deleteStoredFileLocked(ctx context.Context, r Record) error {
+	for _, other := range records.byID {
+		if other.ID != r.ID && (other.URL == r.URL || other.Thumb == r.URL) {
+			return nil
+		}
+	}
+
+	backend, err := backendNamed(r.Backend)
+	if err != nil {
+		return err
+	}
+	deleter, ok := backend.(Deleter)
+	if !ok {
+		// Nothing we can do, the file stays until the host drops it
+		return nil
+	}
+	return deleter.Delete(ctx, r)
+}
+
+func loadRecordsLocked() {
+	if records.byID != nil {
+		return
+	}
+	records.byID = make(map[string]*Record)
+
+	data, err := os.ReadFile(recordsFile)
+	if err != nil {
+		return
+	}
+	var stored []storedRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("Invalid %s: %v", recordsFile, err)
+		return
+	}
+	for _, s := range stored {
+		r := s.Record
+		r.Owner = s.Owner
+		r.Token = s.Token
+		r.ThumbToken = s.ThumbToken
+		records.byID[r.ID] = &r
+	}
+}
+
+func saveRecordsLocked() error {
+	stored := make([]storedRecord, 0, len(records.byID))
+	for _, r := range records.byID {
+		stored = append(stored, storedRecord{Record: *r, Owner: r.Owner, Token: r.Token, ThumbToken: r.ThumbToken})
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].CreatedAt < stored[j].CreatedAt })
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(recordsFile), os.ModePerm); err != nil {
+		return err
+	}
+	// The file holds management tokens
+	return os.WriteFile(recordsFile, data, 0600)
+}